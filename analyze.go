@@ -0,0 +1,173 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// Analysis contains problems found in the layers of a Config.
+// Use Config.Analyze() to get it.
+type Analysis struct {
+	// Redundant contains values of layers that are equal to the merged value of all lower priority layers.
+	// This includes deletion markers for paths that don't exist in lower priority layers.
+	// They can be removed without changing the merged tree.
+	Redundant []Finding
+
+	// Orphaned contains values of writable layers that don't exist in any other layer.
+	// Writable layers are the layer at index 0 and all layers with a write route, see WithWriteRoute().
+	// Orphaned values are usually caused by typos or by settings that were removed from the defaults.
+	//
+	// If there are only writable layers, nothing is reported.
+	Orphaned []Finding
+
+	// Conflicts contains all paths where at least one layer has a node, and at least one other layer has a value.
+	Conflicts []TypeConflict
+}
+
+// Finding describes a single value of a layer.
+type Finding struct {
+	Path  string
+	Index int         // Index of the layer in the list of storages.
+	Name  string      // Name of the layer, if it has one.
+	Value interface{} // Copy of the value.
+}
+
+// TypeConflict describes a path that is a node in some, and a value in other layers.
+type TypeConflict struct {
+	Path   string
+	Nodes  []int // Indices of the layers that contain a node at the path.
+	Values []int // Indices of the layers that contain any other value at the path.
+}
+
+// Analyze checks the current trees of all layers for redundant, orphaned and conflicting elements.
+//
+// The result can be used to clean up configuration files, e.g. by resetting all redundant values with ResetIn().
+// If the config is closed, ErrClosed is returned.
+func (c *Config) Analyze() (Analysis, error) {
+	if c.isClosed() {
+		return Analysis{}, &ErrClosed{}
+	}
+
+	return analyze(c.layers, c.snapshot().layerTrees, c.routes, c.options.MergeRules), nil
+}
+
+// analyze implements Config.Analyze().
+func analyze(layers []*Layer, layerTrees []tree.Node, routes []route, rules tree.MergeRules) Analysis {
+	var a Analysis
+
+	finding := func(path string, index int, v interface{}) Finding {
+		f := Finding{Path: path, Index: index}
+		if index < len(layers) {
+			f.Name = layers[index].Name
+		}
+		f.Value, _ = tree.Marshal(v)
+		return f
+	}
+
+	// Find redundant values by comparing every layer with the merged tree of all lower priority layers.
+	lower := tree.Node{}
+	for i := len(layerTrees) - 1; i >= 0; i-- {
+		walkTree(layerTrees[i], func(path string, v interface{}) {
+			if isNodeValue(v) {
+				return
+			}
+			if isTombstoneValue(v) {
+				if _, ok := lower.Lookup(path); !ok {
+					a.Redundant = append(a.Redundant, finding(path, i, v))
+				}
+				return
+			}
+			if lowerV, ok := lower.Lookup(path); ok && !mergesInto(lowerV, v, rules[path]) && reflect.DeepEqual(v, lowerV) {
+				a.Redundant = append(a.Redundant, finding(path, i, v))
+			}
+		})
+		lower.MergeWithRules(layerTrees[i].Copy(), rules) // Merge a copy, as merging will modify the nodes it inserts.
+	}
+	sort.SliceStable(a.Redundant, func(i, j int) bool { return a.Redundant[i].Index < a.Redundant[j].Index })
+
+	// Find orphaned values of writable layers.
+	writable := map[int]bool{0: true}
+	for _, r := range routes {
+		writable[r.layer] = true
+	}
+	if len(writable) < len(layerTrees) {
+		for i, t := range layerTrees {
+			if !writable[i] {
+				continue
+			}
+			walkTree(t, func(path string, v interface{}) {
+				if isNodeValue(v) || isTombstoneValue(v) {
+					return
+				}
+				for j, other := range layerTrees {
+					if writable[j] {
+						continue
+					}
+					if _, ok := other.Lookup(path); ok {
+						return
+					}
+				}
+				a.Orphaned = append(a.Orphaned, finding(path, i, v))
+			})
+		}
+	}
+
+	// Find paths with conflicting types.
+	conflicts := map[string]*TypeConflict{}
+	var paths []string
+	for i, t := range layerTrees {
+		walkTree(t, func(path string, v interface{}) {
+			if isTombstoneValue(v) {
+				return
+			}
+			tc, ok := conflicts[path]
+			if !ok {
+				tc = &TypeConflict{Path: path}
+				conflicts[path] = tc
+				paths = append(paths, path)
+			}
+			if isNodeValue(v) {
+				tc.Nodes = append(tc.Nodes, i)
+			} else {
+				tc.Values = append(tc.Values, i)
+			}
+		})
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if tc := conflicts[path]; len(tc.Nodes) > 0 && len(tc.Values) > 0 {
+			a.Conflicts = append(a.Conflicts, *tc)
+		}
+	}
+
+	return a
+}
+
+// walkTree calls f for every element of the tree, except the root node itself.
+// Children are visited in sorted order, after their parent.
+func walkTree(t tree.Node, f func(path string, v interface{})) {
+	var recursive func(n tree.Node, prefix string)
+	recursive = func(n tree.Node, prefix string) {
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			path := tree.PathJoin(prefix, k)
+			f(path, n[k])
+			if node, ok := n[k].(tree.Node); ok {
+				recursive(node, path)
+			}
+		}
+	}
+	recursive(t, "")
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+func TestAnalyze(t *testing.T) {
+	c, err := New([]Storage{
+		UseDummyStorage("", tree.Node{"port": 8080, "hots": "example.com", "tls": "on"}),
+		UseDummyStorage("", tree.Node{"port": 8080, "tls": tree.Node{"enabled": true}}),
+		UseDummyStorage("", tree.Node{"port": 80, "host": "localhost"}),
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	a, err := c.Analyze()
+	if err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+
+	wantRedundant := []Finding{{Path: ".port", Index: 0, Value: tree.Number("8080")}}
+	if !reflect.DeepEqual(a.Redundant, wantRedundant) {
+		t.Errorf("Got redundant values %v, want %v", a.Redundant, wantRedundant)
+	}
+
+	wantOrphaned := []Finding{{Path: ".hots", Index: 0, Value: "example.com"}}
+	if !reflect.DeepEqual(a.Orphaned, wantOrphaned) {
+		t.Errorf("Got orphaned values %v, want %v", a.Orphaned, wantOrphaned)
+	}
+
+	wantConflicts := []TypeConflict{{Path: ".tls", Nodes: []int{1}, Values: []int{0}}}
+	if !reflect.DeepEqual(a.Conflicts, wantConflicts) {
+		t.Errorf("Got conflicts %v, want %v", a.Conflicts, wantConflicts)
+	}
+}
@@ -6,33 +6,55 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Dadido3/D3config/tree"
 )
 
-type eventReset struct {
-	path       string
+type eventWrite struct {
+	ctx        context.Context
+	ops        []writeOp // Operations that are applied in order.
 	resultChan chan<- error
+	doneChan   chan<- struct{} // If not nil, it is closed once the change is published.
 }
 
-type eventSet struct {
-	path       string
-	object     interface{}
+type eventGetFrom struct {
+	ctx        context.Context
+	layer      int // Index of the layer to read from.
+	resultChan chan<- layerResult
+}
+
+// layerResult contains the tree of a single layer, or the error that occurred while reading it.
+type layerResult struct {
+	tree tree.Node
+	err  error
+}
+
+type eventReload struct {
+	ctx        context.Context
 	resultChan chan<- error
+	doneChan   chan<- struct{} // Is closed once the reloaded tree is published.
 }
 
 type eventRegister struct {
-	paths      []string
-	callback   func(c *Config, modified, added, removed []string)
-	resultChan chan<- int
+	paths         []string
+	callback      func(c *Config, modified, added, removed []string)
+	eventCallback func(c *Config, e ChangeEvent)
+	skipInitial   bool // Don't send the initial event.
+	resultChan    chan<- int
 }
 
 type eventUnregister struct {
 	id         int
-	resultChan chan<- struct{}
+	resultChan chan<- <-chan struct{} // Receives a channel that is closed once the callback isn't called anymore.
 }
 
 // Config contains the hierarchical configuration data.
@@ -41,18 +63,31 @@ type eventUnregister struct {
 // Use the Set(), Reset() and Get() methods to interact with that tree.
 // Changes made to the config are immediately stored in the the defined storage.
 type Config struct {
+	options Options
+	layers  []*Layer // List of layers, never modified after creation.
+	routes  []route  // Write routes, deepest paths first. Never modified after creation.
+
 	eventChan    chan interface{}
 	listenerChan chan interface{}
 
-	tree      tree.Node // Tree is only modified by the "Tree update handler" goroutine, to prevent deadlocks and out of sync data.
-	treeMutex sync.RWMutex
-
-	waitGroup sync.WaitGroup
+	// The current *Snapshot, it contains the merged tree and the trees of all layers.
+	// It's only replaced by the "Tree update handler" goroutine, to prevent deadlocks and out of sync data.
+	// Published snapshots are never modified, so they can be read without any lock.
+	state atomic.Value
+
+	waitGroup  sync.WaitGroup
+	closeChan  chan struct{} // Is closed once Close() is called, this stops the event handler.
+	closedChan chan struct{} // Is closed once the tree update handler stopped, and no listener is called anymore.
+	closeOnce  sync.Once
+	closeDone  chan struct{} // Is closed once all goroutines stopped and all storages are closed.
+	closeErr   error         // Result of closing the storages, only valid after closeDone is closed.
 }
 
-type listener struct {
-	paths    []string
-	callback func(c *Config, modified, added, removed []string)
+// treeUpdate contains a new (already merged) tree that is compared and distributed to listeners.
+type treeUpdate struct {
+	tree       tree.Node
+	layerTrees []tree.Node       // The unmerged trees the tree was built from.
+	doneChans  []chan<- struct{} // Are closed once the tree is published.
 }
 
 // New returns a new Config object.
@@ -65,138 +100,410 @@ type listener struct {
 // If a file is changed on disk, it is reloaded, and merged with all other files/storages automatically.
 // Changes in the configuration tree will be broadcasted to any listener.
 //
+// Storage changes are debounced, and failed reloads are retried with an exponential backoff.
+// Only storages that signaled a change or got written to are read again, the trees of all other storages are cached.
+// See WithDebounce() and WithRetryBackoff() for details.
+// Automatic reloading can be disabled with WithoutWatchers(), see also Reload() and WithReloadSignal().
+//
+// Options can be passed to change the behavior, see Option.
+//
 // If any of these storage objects couldn't be read from, this function will return an error.
-// On the other hand, if any storage object fails to read later, nothing will reload.
-func New(storages []Storage) (*Config, error) {
+// On the other hand, if any storage object fails to read later, nothing will reload until the problem is fixed.
+// Storages can be wrapped with Optional() or Required() to change that behavior, see Layer.
+func New(storages []Storage, opts ...Option) (*Config, error) {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return NewWithOptions(storages, o)
+}
+
+// NewWithOptions returns a new Config object.
+// It's similar to New(), but it takes an Options object.
+// Use DefaultOptions() as a starting point.
+func NewWithOptions(storages []Storage, o Options) (*Config, error) {
+	if o.RetryMax < o.RetryMin {
+		o.RetryMax = o.RetryMin // Otherwise retries would be scheduled without any delay.
+	}
+
 	c := &Config{
+		options:      o,
 		eventChan:    make(chan interface{}),
 		listenerChan: make(chan interface{}),
+		closeChan:    make(chan struct{}),
+		closedChan:   make(chan struct{}),
+		closeDone:    make(chan struct{}),
+	}
+
+	layers := make([]*Layer, 0, len(storages))
+	names := map[string]struct{}{}
+	for _, storage := range storages {
+		layer := asLayer(storage)
+		if layer.Name != "" {
+			if _, ok := names[layer.Name]; ok {
+				return nil, fmt.Errorf("layer name %q is used more than once", layer.Name)
+			}
+			names[layer.Name] = struct{}{}
+		}
+		layers = append(layers, layer)
+	}
+	c.layers = layers
+
+	if err := checkLockPaths(layers); err != nil {
+		return nil, err
 	}
 
-	readConfig := func(storages []Storage) (tree.Node, error) {
-		result := tree.Node{}
+	routes, err := resolveRoutes(layers, o.WriteRoutes)
+	if err != nil {
+		return nil, err
+	}
+	c.routes = routes
+
+	// The trees of all layers as they were read the last time, and the layers that have to be read again.
+	// The cache is only accessed by the event handler goroutine, or before it's started.
+	// Layers are marked as dirty by their watchers, by writes and by explicit reloads.
+	var cache []tree.Node
+	var dirtyMutex sync.Mutex
+	dirty := make([]bool, len(layers))
+	markDirty := func(indices ...int) {
+		dirtyMutex.Lock()
+		defer dirtyMutex.Unlock()
+		for _, i := range indices {
+			dirty[i] = true
+		}
+	}
+	markAllDirty := func() {
+		dirtyMutex.Lock()
+		defer dirtyMutex.Unlock()
+		for i := range dirty {
+			dirty[i] = true
+		}
+	}
+	markAllDirty() // Nothing is read yet.
+
+	// readTrees returns the trees of all layers.
+	// Only layers that are marked as dirty are read again, all other trees are taken from the cache.
+	// The returned slice must not be modified, as it's used as the cache.
+	readTrees := func(ctx context.Context) ([]tree.Node, error) {
+		dirtyMutex.Lock()
+		var indices []int
+		for i, d := range dirty {
+			if d {
+				indices = append(indices, i)
+				dirty[i] = false
+			}
+		}
+		dirtyMutex.Unlock()
 
-		for i := len(storages) - 1; i >= 0; i-- {
-			storage := storages[i]
-			t, err := storage.Read()
+		trees := make([]tree.Node, len(layers))
+		copy(trees, cache)
+		var skipped []int
+		for _, i := range indices {
+			t, s, err := layers[i].read(ctx, c, i)
 			if err != nil {
+				markDirty(indices...) // Try again the next time.
 				return nil, err
 			}
-			result.Merge(t)
+			if s {
+				skipped = append(skipped, i)
+			}
+			trees[i] = t
 		}
+		markDirty(skipped...) // Skipped layers are read again on the next reload, until they can be read.
+		cache = trees
 
-		return result, nil
+		return trees, nil
 	}
 
-	setObject := func(storages []Storage, path string, obj interface{}) error {
-		if len(storages) <= 0 {
-			return fmt.Errorf("there are no storage objects to write to")
+	// mergeTrees returns the merged tree, it shares all unmodified subtrees with the layer trees.
+	// The layer trees must not be modified afterwards.
+	mergeTrees := func(trees []tree.Node) tree.Node {
+		builder := tree.NewBuilder(o.MergeRules)
+
+		for i := len(trees) - 1; i >= 0; i-- {
+			builder.Merge(trees[i])
 		}
-		storage := storages[0]
+		enforceLocks(layers, trees, builder)
+
+		return builder.Node()
+	}
 
-		t, err := storage.Read()
+	readConfig := func(ctx context.Context) (treeUpdate, error) {
+		trees, err := readTrees(ctx)
 		if err != nil {
-			return err
+			return treeUpdate{}, err
 		}
 
-		if err := t.Set(path, obj); err != nil {
-			return err
-		}
+		result := mergeTrees(trees)
 
-		if err := storage.Write(t); err != nil {
-			return err
+		if o.Validator != nil {
+			if err := o.Validator(result); err != nil {
+				return treeUpdate{}, &ErrValidation{err}
+			}
 		}
 
-		return nil
+		return treeUpdate{tree: result, layerTrees: trees}, nil
 	}
 
-	resetObject := func(storages []Storage, path string) error {
-		if len(storages) <= 0 {
+	// writeLayers applies the operations on the trees of their layers, and writes all modified trees back.
+	// Every affected layer is read once, and nothing is written if any operation fails.
+	writeLayers := func(ctx context.Context, ops []writeOp) error {
+		if len(layers) <= 0 {
 			return fmt.Errorf("there are no storage objects to write to")
 		}
-		storage := storages[0]
 
-		t, err := storage.Read()
+		ops, err := c.runBeforeHooks(ops)
 		if err != nil {
 			return err
 		}
-
-		if err := t.Remove(path); err != nil {
+		if err := c.checkLocks(ops); err != nil {
 			return err
 		}
 
-		if err := storage.Write(t); err != nil {
-			return err
+		modified := map[int]tree.Node{}
+		var order []int // Indices of modified layers, in the order of their first modification.
+		for _, op := range ops {
+			t, ok := modified[op.layer]
+			if !ok {
+				var err error
+				if t, err = readStorage(ctx, layers[op.layer].Storage); err != nil {
+					return err
+				}
+				t = t.Copy() // The storage may return a tree that is shared with the cache and the published snapshot.
+				modified[op.layer] = t
+				order = append(order, op.layer)
+			}
+			if err := op.apply(t); err != nil {
+				return err
+			}
+		}
+
+		// Check the resulting tree before anything is written.
+		var oldTree, newTree tree.Node
+		if o.Validator != nil || len(o.AfterWrite) > 0 {
+			trees, err := readTrees(ctx)
+			if err != nil {
+				return err
+			}
+			oldTree = mergeTrees(trees)
+			trees = append([]tree.Node{}, trees...) // Don't modify the cache.
+			for i, t := range modified {
+				trees[i] = t
+			}
+			newTree = mergeTrees(trees)
+		}
+		if o.Validator != nil {
+			if err := o.Validator(newTree); err != nil {
+				return &ErrValidation{err}
+			}
+		}
+
+		for _, i := range order {
+			if err := writeStorage(ctx, layers[i].Storage, modified[i]); err != nil {
+				return err
+			}
+			markDirty(i) // Read the written tree back, as the storage may have changed it.
+		}
+
+		if len(o.AfterWrite) > 0 {
+			event := newChangeEvent(oldTree, newTree)
+			for _, hook := range o.AfterWrite {
+				hook(event)
+			}
 		}
 
 		return nil
 	}
 
+	changeChan := make(chan struct{}, 1)    // Channel for storage changes that trigger a reload of the config tree.
+	watchersStopChan := make(chan struct{}) // Is closed once the watchers are unregistered.
+
+	// Register watchers before the first read, so that no change gets lost in between.
+	unregisterWatchers := func() {
+		if !o.DisableWatchers {
+			for _, layer := range layers {
+				layer.Storage.RegisterWatcher(nil)
+			}
+		}
+		close(watchersStopChan)
+	}
+	if !o.DisableWatchers {
+		for i, layer := range layers {
+			if s, ok := layer.Storage.(PollingStorage); ok && o.PollInterval > 0 && s.PollInterval() == 0 {
+				s.SetPollInterval(o.PollInterval)
+			}
+
+			// Every storage gets its own channel, so that only the changed layers have to be read again.
+			layerChan := make(chan struct{}, 1)
+			if err := layer.Storage.RegisterWatcher(layerChan); err != nil {
+				c.reportError(err)
+				continue
+			}
+			go func(i int) {
+				for {
+					select {
+					case <-layerChan:
+						markDirty(i)
+						select {
+						case changeChan <- struct{}{}:
+						default:
+						}
+					case <-watchersStopChan:
+						return
+					}
+				}
+			}(i)
+		}
+	}
+
 	// Try to read storages and build config tree.
-	if tree, err := readConfig(storages); err == nil {
-		c.tree = tree // No need to lock mutex here, as nothing else can access the tree.
+	if u, err := readConfig(context.Background()); err == nil {
+		c.publishSnapshot(1, u.tree, u.layerTrees)
 	} else {
+		unregisterWatchers()
 		return nil, err
 	}
 
-	treeChan := make(chan tree.Node, 1) // New (already merged) trees are put here to be compared and distributed to listeners.
+	treeChan := make(chan treeUpdate, 1) // New (already merged) trees are put here to be compared and distributed to listeners.
+
+	// Write tree update into tree channel, or replace the queued element if the goroutine is busy. This is non blocking.
+	publish := func(update treeUpdate) {
+		select {
+		case treeChan <- update:
+		default:
+			select {
+			case replaced := <-treeChan:
+				update.doneChans = append(replaced.doneChans, update.doneChans...)
+			default:
+			}
+			treeChan <- update
+		}
+	}
 
 	// Event handler goroutine.
 	c.waitGroup.Add(1)
 	go func() {
 		defer c.waitGroup.Done()
 		defer close(treeChan)
+		defer unregisterWatchers()
+
+		reloadTimer := time.NewTimer(0)
+		<-reloadTimer.C // Start with a drained timer.
+		defer reloadTimer.Stop()
+		var reloadTimerChan <-chan time.Time // Is nil if there is no reload scheduled.
+		scheduleReload := func(d time.Duration) {
+			if !reloadTimer.Stop() {
+				select {
+				case <-reloadTimer.C:
+				default:
+				}
+			}
+			reloadTimer.Reset(d)
+			reloadTimerChan = reloadTimer.C
+		}
+		failures := 0 // Number of consecutive failed reloads.
 
-		changeChan := make(chan struct{}, 1) // Channel for storage changes that trigger a reload of the config tree.
-		defer close(changeChan)
+		// publishNow reloads the config immediately, and closes doneChan once the tree is published.
+		publishNow := func(doneChan chan<- struct{}) {
+			update, err := readConfig(context.Background())
+			if err != nil {
+				c.reportError(err)
+				close(doneChan)
+				return
+			}
+			update.doneChans = []chan<- struct{}{doneChan}
+			publish(update)
+		}
 
-		for _, storage := range storages {
-			storage.RegisterWatcher(changeChan) // TODO: Handle error
-			defer storage.RegisterWatcher(nil)
+		var signalChan chan os.Signal // Is nil if there are no reload signals defined.
+		if len(o.ReloadSignals) > 0 {
+			signalChan = make(chan os.Signal, 1)
+			signal.Notify(signalChan, o.ReloadSignals...)
+			defer signal.Stop(signalChan)
 		}
 
 		for {
 			select {
+			case <-signalChan:
+				failures = 0
+				markAllDirty()
+				scheduleReload(0)
+
 			case <-changeChan:
-				tree, err := readConfig(storages)
+				// Delay the reload until there are no more changes for some time.
+				failures = 0
+				scheduleReload(o.Debounce)
+
+			case <-reloadTimerChan:
+				reloadTimerChan = nil
+				update, err := readConfig(context.Background())
 				if err != nil {
-					// TODO: Handle error
-					log.Printf("D3config: %v", err)
-					continue
-				}
-				// Write tree into tree channel, or replace the queued element if the goroutine is busy. This is non blocking.
-				select {
-				case treeChan <- tree:
-				default:
-					select {
-					case <-treeChan:
-					default:
+					failures++
+					// Only report errors that persist after the first retry, as they may be caused by half written files.
+					if failures > 1 || o.RetryMin <= 0 {
+						c.reportError(err)
 					}
-					treeChan <- tree
+					if o.RetryMin > 0 {
+						// Retry with exponential backoff.
+						backoff := o.RetryMin
+						for i := 1; i < failures && backoff < o.RetryMax; i++ {
+							backoff *= 2
+						}
+						if backoff > o.RetryMax {
+							backoff = o.RetryMax
+						}
+						scheduleReload(backoff)
+					}
+					continue
 				}
+				failures = 0
+				publish(update)
 
-			case u, ok := <-c.eventChan:
-				if !ok {
-					return
-				}
+			case <-c.closeChan:
+				return
+
+			case u := <-c.eventChan:
 				switch u := u.(type) {
-				case eventReset:
-					err := resetObject(storages, u.path)
-					u.resultChan <- err
-					// Write to changeChan in a non blocking way.
-					/*select {
-					case changeChan <- struct{}{}:
-					default:
-					}*/
-
-				case eventSet:
-					err := setObject(storages, u.path, u.object)
+				case eventWrite:
+					// Nobody waits for the result anymore, if the context is done.
+					if err := u.ctx.Err(); err != nil {
+						u.resultChan <- err
+						continue
+					}
+					err := writeLayers(u.ctx, u.ops)
+					if err == nil {
+						if u.doneChan != nil {
+							publishNow(u.doneChan) // The change is already written, so the tree has to be updated regardless of the context.
+						} else {
+							scheduleReload(o.Debounce) // Read the written layers back, even if their storages have no watchers.
+						}
+					}
 					u.resultChan <- err
-					// Write to changeChan in a non blocking way.
-					/*select {
-					case changeChan <- struct{}{}:
-					default:
-					}*/
+
+				case eventGetFrom:
+					if err := u.ctx.Err(); err != nil {
+						u.resultChan <- layerResult{err: err}
+						continue
+					}
+					t, err := readStorage(u.ctx, layers[u.layer].Storage)
+					u.resultChan <- layerResult{t, err}
+
+				case eventReload:
+					if err := u.ctx.Err(); err != nil {
+						u.resultChan <- err
+						continue
+					}
+					markAllDirty()
+					update, err := readConfig(u.ctx)
+					if err != nil {
+						u.resultChan <- err
+						continue
+					}
+					failures = 0
+					update.doneChans = []chan<- struct{}{u.doneChan}
+					publish(update)
+					u.resultChan <- nil
 
 				default:
 					log.Panicf("Got invalid element %v of type %T in event channel.", u, u)
@@ -205,76 +512,75 @@ func New(storages []Storage) (*Config, error) {
 		}
 	}()
 
-	sendChanges := func(l listener, modified, added, removed []string) {
-		for _, lPath := range l.paths {
-			tempModified, tempAdded, tempRemoved := []string{}, []string{}, []string{}
-			for _, path := range modified {
-				if tree.PathContains(path, lPath) {
-					tempModified = append(tempModified, path)
-				}
-			}
-			for _, path := range added {
-				if tree.PathContains(path, lPath) {
-					tempAdded = append(tempAdded, path)
-				}
-			}
-			for _, path := range removed {
-				if tree.PathContains(path, lPath) {
-					tempRemoved = append(tempRemoved, path)
-				}
-			}
-			if len(tempModified) > 0 || len(tempAdded) > 0 || len(tempRemoved) > 0 {
-				l.callback(c, tempModified, tempAdded, tempRemoved)
-			}
-		}
-	}
-
 	// Tree update handler goroutine. (Also distributes tree events to listeners)
 	c.waitGroup.Add(1)
 	go func() {
 		defer c.waitGroup.Done()
+		defer close(c.closedChan)
 
-		listeners := make(map[int]listener) // List of registered listeners.
+		listeners := make(map[int]*listener) // List of registered listeners.
 		listenersCounter := 0
+		index := &listenerIndex{} // Index of the paths of all registered listeners.
+
+		// Stop all listeners, and wait until their callbacks returned.
+		defer func() {
+			for _, l := range listeners {
+				l.stop()
+			}
+			for _, l := range listeners {
+				<-l.doneChan
+			}
+		}()
 
 		for {
 			select {
-			case t, ok := <-treeChan:
+			case u, ok := <-treeChan:
 				if !ok {
 					return
 				}
 
-				modified, added, removed := c.tree.Compare(t) // No mutex needed, as the tree is only modified in this goroutine.
-				c.treeMutex.Lock()
-				c.tree = t
-				c.treeMutex.Unlock()
-
-				wg := sync.WaitGroup{}
-				for _, l := range listeners {
-					wg.Add(1)
-					go func(l listener) {
-						defer wg.Done()
-						sendChanges(l, modified, added, removed)
-					}(l)
+				// Share all unchanged subtrees with the previous tree, this way the comparison can skip them.
+				old := c.snapshot()
+				newTree := u.tree.Share(old.tree)
+				event := newChangeEvent(old.tree, newTree)
+				c.publishSnapshot(old.generation+1, newTree, u.layerTrees)
+				close(old.genChan)
+				for _, doneChan := range u.doneChans {
+					close(doneChan)
+				}
+
+				for l, e := range index.dispatch(event) {
+					l.push(e)
 				}
-				wg.Wait()
 
 			case e := <-c.listenerChan:
 				switch e := e.(type) {
 				case eventRegister:
-					l := listener{e.paths, e.callback}
-					if len(l.paths) == 0 {
-						l.paths = []string{""} // Add at least one empty path that fits all, if there are not paths defined.
-					}
+					l := newListener(c, listenersCounter, e.paths, e.callback, e.eventCallback)
 					listeners[listenersCounter] = l
+					index.add(l)
 					e.resultChan <- listenersCounter
 					listenersCounter++
-					modified, added, removed := tree.Node{}.Compare(c.tree) // Compare empty tree with current one.
-					sendChanges(l, modified, added, removed)                // No mutex needed, as the tree is only modified in this goroutine.
+					if !e.skipInitial {
+						// Compare empty tree with current one.
+						if e := newChangeEvent(tree.Node{}, c.snapshot().tree).filter(l.paths...); !e.empty() {
+							l.push(e)
+						}
+					}
 
 				case eventUnregister:
-					delete(listeners, e.id)
-					e.resultChan <- struct{}{}
+					var doneChan <-chan struct{}
+					if l, ok := listeners[e.id]; ok {
+						l.stop()
+						doneChan = l.doneChan
+						delete(listeners, e.id)
+						index.remove(l)
+					} else {
+						closed := make(chan struct{})
+						close(closed)
+						doneChan = closed
+					}
+					e.resultChan <- doneChan
 
 				default:
 					log.Panicf("Got invalid element %v of type %T in listener channel.", e, e)
@@ -289,8 +595,8 @@ func New(storages []Storage) (*Config, error) {
 
 // NewOrPanic returns a new Config object.
 // It's similar to New(), but it panics instead of returning an error.
-func NewOrPanic(storages []Storage) *Config {
-	res, err := New(storages)
+func NewOrPanic(storages []Storage, opts ...Option) *Config {
+	res, err := New(storages, opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -300,50 +606,374 @@ func NewOrPanic(storages []Storage) *Config {
 
 // RegisterCallback will add the given callback to the internal listener list.
 // A list of paths can be defined to ignore all events that are not inside the given paths.
+// Paths can contain wildcards, like ".services.*.port" or ".**.port", see tree.PathMatch().
+//
+// Every callback is called from its own goroutine, one change at a time, in the order the changes happened.
+// A slow callback doesn't delay any other callback, and panics are recovered and reported as ErrCallbackPanic.
+// As changes are queued, the tree may already contain newer changes when the callback is called.
 //
 // An integer is returned, that can be used to Unregister() the callback.
+// If the config is closed, the callback is not registered and -1 is returned.
 func (c *Config) RegisterCallback(paths []string, callback func(c *Config, modified, added, removed []string)) int {
-	resultChan := make(chan int)
-	c.listenerChan <- eventRegister{paths, callback, resultChan}
-	return <-resultChan
+	id, _ := c.register(context.Background(), eventRegister{paths: paths, callback: callback})
+	return id
+}
+
+// RegisterCallbackContext is similar to RegisterCallback(), but it gives up once the context is done.
+// In that case, or if the config is closed, the callback is not registered and an error is returned.
+//
+// The context is only used for the registration, use UnregisterCallback() to remove the callback again.
+func (c *Config) RegisterCallbackContext(ctx context.Context, paths []string, callback func(c *Config, modified, added, removed []string)) (int, error) {
+	return c.register(ctx, eventRegister{paths: paths, callback: callback})
+}
+
+// RegisterEventCallback will add the given callback to the internal listener list.
+// A list of paths can be defined to ignore all events that are not inside the given paths.
+// Paths can contain wildcards, see tree.PathMatch().
+//
+// Other than RegisterCallback(), the callback gets the old and new elements of every changed path, and the old and new trees.
+// The elements and trees must not be modified.
+//
+// An integer is returned, that can be used to Unregister() the callback.
+// If the config is closed, the callback is not registered and -1 is returned.
+func (c *Config) RegisterEventCallback(paths []string, callback func(c *Config, e ChangeEvent)) int {
+	id, _ := c.register(context.Background(), eventRegister{paths: paths, eventCallback: callback})
+	return id
+}
+
+// register adds the listener in the tree update handler goroutine, and returns its id.
+// It returns -1 and ErrClosed if the config is closed, or -1 and the context's error if the context is done.
+func (c *Config) register(ctx context.Context, e eventRegister) (int, error) {
+	if c.isClosed() {
+		return -1, &ErrClosed{}
+	}
+
+	resultChan := make(chan int, 1)
+	e.resultChan = resultChan
+	select {
+	case c.listenerChan <- e:
+		return <-resultChan, nil
+	case <-c.closedChan:
+		return -1, &ErrClosed{}
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
 }
 
 // UnregisterCallback removes a callback from the internal listener list.
+//
+// No new call of the callback is started once this returns, but a call that is already running is not waited for.
+// This way it's possible to unregister a callback from within itself.
+//
+// If the config is closed, this does nothing.
 func (c *Config) UnregisterCallback(id int) {
-	resultChan := make(chan struct{})
-	c.listenerChan <- eventUnregister{id, resultChan}
-	<-resultChan
+	resultChan := make(chan (<-chan struct{}), 1)
+	select {
+	case c.listenerChan <- eventUnregister{id, resultChan}:
+		<-resultChan
+	case <-c.closedChan:
+	}
 }
 
 // Set changes the element at the given path.
 //
 // It's possible to modify the root node, with the path "", if the passed object is a map or a structure.
 //
-// Changes are written immediately to the to the storage object.
+// Changes are written immediately to the storage object at index 0, or to the layer defined by a write route, see WithWriteRoute().
+// Parts of the object that fall under other write routes are written into their respective layers.
+// If WithSetWaitsForReload() is used, this waits until the change is visible in the tree.
 func (c *Config) Set(path string, object interface{}) error {
-	resultChan := make(chan error)
-	c.eventChan <- eventSet{path, object, resultChan}
-	return <-resultChan
+	return c.SetContext(context.Background(), path, object)
 }
 
-// Reset will remove the element at the given path.
+// SetContext is similar to Set(), but it gives up once the context is done, and returns the context's error.
+// The context is passed to storages that implement ContextStorage.
+//
+// A change that is already being written when the context is done may still be applied.
+func (c *Config) SetContext(ctx context.Context, path string, object interface{}) error {
+	value, err := tree.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return c.write(ctx, c.routeSet(path, value))
+}
+
+// SetIn changes the element at the given path in the layer with the given name.
+// Use Named() to give layers a name.
+//
+// Write routes are ignored, other than that it behaves like Set().
+func (c *Config) SetIn(layer, path string, object interface{}) error {
+	return c.SetInContext(context.Background(), layer, path, object)
+}
+
+// SetInContext is similar to SetIn(), but it gives up once the context is done, see SetContext().
+func (c *Config) SetInContext(ctx context.Context, layer, path string, object interface{}) error {
+	index, err := c.layerIndex(layer)
+	if err != nil {
+		return err
+	}
+	value, err := tree.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return c.write(ctx, []writeOp{{layer: index, path: path, value: value}})
+}
+
+// Reset will remove the element at the given path from the storage object at index 0, or from the layer defined by a write route, see WithWriteRoute().
+// Everything inside the path that falls under other write routes is removed from their respective layers.
 // Lower priority properties will be visible again, if available.
+//
+// If WithSetWaitsForReload() is used, this waits until the change is visible in the tree.
 func (c *Config) Reset(path string) error {
-	resultChan := make(chan error)
-	c.eventChan <- eventReset{path, resultChan}
-	return <-resultChan
+	return c.ResetContext(context.Background(), path)
+}
+
+// ResetContext is similar to Reset(), but it gives up once the context is done, see SetContext().
+func (c *Config) ResetContext(ctx context.Context, path string) error {
+	return c.write(ctx, c.routeReset(path))
+}
+
+// Delete removes the element at the given path from the merged tree, even if it's defined in lower priority storages.
+// A deletion marker (see tree.Tombstone) is written into the storage object at index 0, or into the layer defined by a write route, see WithWriteRoute().
+// Use Reset() to remove the marker again.
+//
+// If WithSetWaitsForReload() is used, this waits until the change is visible in the tree.
+func (c *Config) Delete(path string) error {
+	return c.DeleteContext(context.Background(), path)
+}
+
+// DeleteContext is similar to Delete(), but it gives up once the context is done, see SetContext().
+func (c *Config) DeleteContext(ctx context.Context, path string) error {
+	return c.write(ctx, c.routeDelete(path))
+}
+
+// ResetIn will remove the element at the given path from the layer with the given name.
+// Use Named() to give layers a name.
+//
+// Write routes are ignored, other than that it behaves like Reset().
+func (c *Config) ResetIn(layer, path string) error {
+	return c.ResetInContext(context.Background(), layer, path)
+}
+
+// ResetInContext is similar to ResetIn(), but it gives up once the context is done, see SetContext().
+func (c *Config) ResetInContext(ctx context.Context, layer, path string) error {
+	index, err := c.layerIndex(layer)
+	if err != nil {
+		return err
+	}
+	return c.write(ctx, []writeOp{{layer: index, path: path, reset: true}})
+}
+
+// write applies the operations in the event handler goroutine, and waits for the result.
+func (c *Config) write(ctx context.Context, ops []writeOp) error {
+	resultChan, doneChan := make(chan error, 1), c.newDoneChan()
+	if err := c.sendEvent(ctx, eventWrite{ctx, ops, resultChan, doneChan}); err != nil {
+		return err
+	}
+	if err := c.waitResult(ctx, resultChan); err != nil {
+		return err
+	}
+	if doneChan != nil {
+		return c.waitPublished(ctx, doneChan)
+	}
+	return nil
+}
+
+// sendEvent passes the event to the event handler goroutine.
+// It returns ErrClosed if the config is closed, or the context's error if the context is done.
+func (c *Config) sendEvent(ctx context.Context, e interface{}) error {
+	if c.isClosed() {
+		return &ErrClosed{}
+	}
+
+	select {
+	case c.eventChan <- e:
+		return nil
+	case <-c.closeChan:
+		return &ErrClosed{}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitResult returns the result of an event, or the context's error if the context is done before.
+// The result channel has to be buffered, so that the event handler doesn't block if nobody waits for the result.
+func (c *Config) waitResult(ctx context.Context, resultChan <-chan error) error {
+	select {
+	case err := <-resultChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitPublished waits until doneChan is closed, or until the tree update handler stopped.
+// It returns the context's error if the context is done before.
+func (c *Config) waitPublished(ctx context.Context, doneChan <-chan struct{}) error {
+	select {
+	case <-doneChan:
+	case <-c.closedChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// isClosed returns whether Close() has been called.
+func (c *Config) isClosed() bool {
+	select {
+	case <-c.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// layerIndex returns the index of the layer with the given name.
+func (c *Config) layerIndex(name string) (int, error) {
+	if name != "" {
+		for i, layer := range c.layers {
+			if layer.Name == name {
+				return i, nil
+			}
+		}
+	}
+	return 0, &ErrLayerNotFound{name}
+}
+
+// newDoneChan returns a channel to wait for a change to be published, or nil if Set() and Reset() shouldn't wait.
+func (c *Config) newDoneChan() chan struct{} {
+	if !c.options.SetWaitsForReload {
+		return nil
+	}
+	return make(chan struct{})
+}
+
+// reportError passes the error to the error handler, or writes it into the logger.
+func (c *Config) reportError(err error) {
+	if c.options.ErrorHandler != nil {
+		c.options.ErrorHandler(err)
+	} else if c.options.Logger != nil {
+		c.options.Logger.Printf("D3config: %v", err)
+	}
+}
+
+// Reload reads all storages again, and waits until the new tree is published.
+// Any error that occurred while reading is returned, in this case the tree stays unchanged.
+//
+// This is useful if watchers are disabled with WithoutWatchers(), but it can be used in any case.
+func (c *Config) Reload() error {
+	return c.ReloadContext(context.Background())
+}
+
+// ReloadContext is similar to Reload(), but it gives up once the context is done, and returns the context's error.
+// The context is passed to storages that implement ContextStorage.
+func (c *Config) ReloadContext(ctx context.Context) error {
+	resultChan, doneChan := make(chan error, 1), make(chan struct{})
+	if err := c.sendEvent(ctx, eventReload{ctx, resultChan, doneChan}); err != nil {
+		return err
+	}
+	if err := c.waitResult(ctx, resultChan); err != nil {
+		return err
+	}
+	return c.waitPublished(ctx, doneChan)
 }
 
 // Get will marshal the elements at path into the given object.
 func (c *Config) Get(path string, object interface{}) error {
-	c.treeMutex.RLock()
-	defer c.treeMutex.RUnlock()
+	if c.isClosed() {
+		return &ErrClosed{}
+	}
 
-	return c.tree.Get(path, object)
+	return c.snapshot().tree.Get(path, object)
 }
 
-// Close will free all resources/watchers.
-func (c *Config) Close() {
-	close(c.eventChan)
-	c.waitGroup.Wait()
+// GetMatches returns copies of all elements whose path matches the given pattern, mapped by their path.
+// See tree.PathMatch() for the pattern syntax.
+func (c *Config) GetMatches(pattern string) (map[string]interface{}, error) {
+	if tree.PathSplit(pattern)[0] != "" {
+		return nil, &tree.ErrPathInvalid{Path: pattern, Reason: "First path element has to be empty"}
+	}
+	if c.isClosed() {
+		return nil, &ErrClosed{}
+	}
+
+	t := c.snapshot().tree
+
+	result := map[string]interface{}{}
+	for _, path := range t.Matches(pattern) {
+		v, _ := t.Lookup(path)
+		var err error
+		if result[path], err = tree.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetFrom will marshal the elements at path of the layer with the given name into the given object.
+// The layer is read directly from its storage, without merging it with any other layer.
+// Use Named() to give layers a name.
+func (c *Config) GetFrom(layer, path string, object interface{}) error {
+	return c.GetFromContext(context.Background(), layer, path, object)
+}
+
+// GetFromContext is similar to GetFrom(), but it gives up once the context is done, and returns the context's error.
+// The context is passed to storages that implement ContextStorage.
+func (c *Config) GetFromContext(ctx context.Context, layer, path string, object interface{}) error {
+	index, err := c.layerIndex(layer)
+	if err != nil {
+		return err
+	}
+
+	resultChan := make(chan layerResult, 1)
+	if err := c.sendEvent(ctx, eventGetFrom{ctx, index, resultChan}); err != nil {
+		return err
+	}
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			return r.err
+		}
+		return r.tree.Get(path, object)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close will free all resources/watchers, and waits until all callbacks returned.
+// Storages that implement io.Closer are closed too, the first error of that is returned.
+//
+// Afterwards all methods return ErrClosed.
+// It's safe to call Close more than once, every call returns the same result.
+// Close must not be called from within a callback, as it would wait for itself.
+func (c *Config) Close() error {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext is similar to Close(), but it stops waiting once the context is done.
+// In that case the context's error is returned, and the config is closed in the background.
+func (c *Config) CloseContext(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		go func() {
+			c.waitGroup.Wait()
+			for _, layer := range c.layers {
+				if closer, ok := layer.Storage.(io.Closer); ok {
+					if err := closer.Close(); err != nil && c.closeErr == nil {
+						c.closeErr = err
+					}
+				}
+			}
+			close(c.closeDone)
+		}()
+	})
+
+	select {
+	case <-c.closeDone:
+		return c.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -6,8 +6,19 @@
 package config
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/Dadido3/D3config/tree"
 )
 
 func TestSimple1(t *testing.T) {
@@ -29,3 +40,878 @@ func TestSimple1(t *testing.T) {
 		t.Errorf("Set() failed: %v", err)
 	}
 }
+
+// testStorage is a storage in RAM that can be modified from tests, including change signals and read errors.
+type testStorage struct {
+	sync.Mutex
+	tree       tree.Node
+	readErr    error
+	reads      int
+	changeChan chan<- struct{}
+}
+
+func (s *testStorage) Read() (tree.Node, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.reads++
+	if s.readErr != nil {
+		return nil, s.readErr
+	}
+	return s.tree.Copy(), nil
+}
+
+func (s *testStorage) Write(t tree.Node) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.tree = t.Copy()
+	return nil
+}
+
+func (s *testStorage) RegisterWatcher(changeChan chan<- struct{}) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.changeChan = changeChan
+	return nil
+}
+
+// change sets the value at path, and signals the change.
+func (s *testStorage) change(path string, obj interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.tree == nil {
+		s.tree = tree.Node{}
+	}
+	s.tree.Set(path, obj)
+	if s.changeChan != nil {
+		select {
+		case s.changeChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	s := &testStorage{tree: tree.Node{}}
+
+	c, err := New([]Storage{s}, WithDebounce(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	var mutex sync.Mutex
+	calls := 0
+	c.RegisterCallback(nil, func(c *Config, modified, added, removed []string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+	})
+
+	// Simulate a burst of change events that belong to a single edit.
+	for i := 0; i < 10; i++ {
+		s.change(".value", i)
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if calls != 1 { // There is no initial call for an empty tree, so this is the call for all the changes.
+		t.Errorf("Callback was called %d times, want %d", calls, 1)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.reads != 2 {
+		t.Errorf("Storage was read %d times, want %d", s.reads, 2)
+	}
+	var v int
+	if err := c.Get(".value", &v); err != nil || v != 9 {
+		t.Errorf("Got value %d (%v), want %d", v, err, 9)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	s := &testStorage{tree: tree.Node{}}
+
+	c, err := New([]Storage{s}, WithDebounce(0), WithRetryBackoff(10*time.Millisecond, 40*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	s.Lock()
+	s.readErr = fmt.Errorf("half written file")
+	s.Unlock()
+	s.change(".value", 123)
+	time.Sleep(100 * time.Millisecond)
+
+	// Fix the storage without signaling a change, the retry has to pick it up.
+	s.Lock()
+	s.readErr = nil
+	s.Unlock()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		var v int
+		if err := c.Get(".value", &v); err == nil && v == 123 {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("Failed reload wasn't retried")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestRetryBackoff_InvalidMax(t *testing.T) {
+	s := &testStorage{tree: tree.Node{}}
+
+	// A max value below min is treated as min, retries must not spin.
+	c, err := New([]Storage{s}, WithDebounce(0), WithRetryBackoff(50*time.Millisecond, 0), WithErrorHandler(func(err error) {}))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	s.Lock()
+	s.readErr = fmt.Errorf("broken storage")
+	s.reads = 0
+	s.Unlock()
+	s.change(".value", 123)
+	time.Sleep(300 * time.Millisecond)
+
+	s.Lock()
+	defer s.Unlock()
+	if s.reads > 10 {
+		t.Errorf("Storage was read %d times in 300 ms, want at most %d", s.reads, 10)
+	}
+}
+
+func TestReload(t *testing.T) {
+	s := &testStorage{tree: tree.Node{}}
+
+	c, err := New([]Storage{s}, WithoutWatchers())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	s.change(".value", 123)
+	if s.changeChan != nil {
+		t.Fatalf("Watcher was registered")
+	}
+
+	if err := c.Reload(); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	var v int
+	if err := c.Get(".value", &v); err != nil || v != 123 {
+		t.Errorf("Got value %d (%v), want %d", v, err, 123)
+	}
+
+	s.Lock()
+	s.readErr = fmt.Errorf("broken storage")
+	s.Unlock()
+	if err := c.Reload(); err == nil {
+		t.Errorf("Reload() didn't return an error")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	s := &testStorage{tree: tree.Node{"port": tree.Number("8080")}}
+
+	var mutex sync.Mutex
+	var handledErrors []error
+
+	validator := func(t tree.Node) error {
+		if port := t.GetInt64(".port", 0); port < 1 || port > 65535 {
+			return fmt.Errorf("port %d out of range", port)
+		}
+		return nil
+	}
+
+	c, err := New([]Storage{s}, WithDebounce(0), WithRetryBackoff(0, 0), WithValidator(validator), WithSetWaitsForReload(), WithErrorHandler(func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		handledErrors = append(handledErrors, err)
+	}))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	// The change has to be visible immediately.
+	if err := c.Set(".port", 1234); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	var port int
+	if err := c.Get(".port", &port); err != nil || port != 1234 {
+		t.Errorf("Got port %d (%v), want %d", port, err, 1234)
+	}
+
+	// Invalid changes are not written.
+	err = c.Set(".port", 123456)
+	if _, ok := err.(*ErrValidation); !ok {
+		t.Errorf("Set() returned %v, want validation error", err)
+	}
+	s.Lock()
+	if v := s.tree.GetInt64(".port", 0); v != 1234 {
+		t.Errorf("Storage contains port %d, want %d", v, 1234)
+	}
+	s.Unlock()
+
+	// Invalid changes of storages are rejected and reported.
+	s.change(".port", 0)
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Get(".port", &port); err != nil || port != 1234 {
+		t.Errorf("Got port %d (%v), want %d", port, err, 1234)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(handledErrors) != 1 {
+		t.Fatalf("Got %d errors, want %d", len(handledErrors), 1)
+	}
+	if _, ok := handledErrors[0].(*ErrValidation); !ok {
+		t.Errorf("Got error %v, want validation error", handledErrors[0])
+	}
+}
+
+func TestMergeRules(t *testing.T) {
+	type Settings struct {
+		Plugins []string `conf:"plugins,merge=append"`
+	}
+	rules, err := tree.MergeRulesFromStruct(Settings{})
+	if err != nil {
+		t.Fatalf("MergeRulesFromStruct() failed: %v", err)
+	}
+
+	c, err := New([]Storage{
+		UseDummyStorage("", tree.Node{"plugins": []string{"c"}, "limits": tree.Node{"cpu": 4}}),
+		UseDummyStorage("", tree.Node{"plugins": []string{"a", "b"}, "limits": tree.Node{"cpu": 1, "memory": 2}}),
+	}, WithMergeRules(rules), WithMergeRule(".limits", tree.MergeRule{Mode: tree.MergeReplaceNode}))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	var settings Settings
+	if err := c.Get("", &settings); err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(settings.Plugins, want) {
+		t.Errorf("Got plugins %v, want %v", settings.Plugins, want)
+	}
+	var limits tree.Node
+	if err := c.Get(".limits", &limits); err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if want := (tree.Node{"cpu": tree.Number("4")}); !reflect.DeepEqual(limits, want) {
+		t.Errorf("Got limits %v, want %v", limits, want)
+	}
+
+	// Explain() follows the rules.
+	e, err := c.Explain(".plugins")
+	if err != nil {
+		t.Fatalf("Explain() failed: %v", err)
+	}
+	if e.Winner != 0 || !reflect.DeepEqual(e.Merged, []int{1}) || e.Shadowed != nil {
+		t.Errorf("Got winner %d, merged %v and shadowed %v, want 0, [1] and []", e.Winner, e.Merged, e.Shadowed)
+	}
+	e, err = c.Explain(".limits.memory")
+	if err != nil {
+		t.Fatalf("Explain() failed: %v", err)
+	}
+	if e.Found || !reflect.DeepEqual(e.Shadowed, []int{1}) {
+		t.Errorf("Got found %v and shadowed %v, want false and [1]", e.Found, e.Shadowed)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "config.yml")
+	c, err := New([]Storage{
+		UseYAMLFile(filePath),
+		UseDummyStorage(".todo", []string{"a", "b"}),
+	}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Delete(".todo"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	var todo []string
+	if err := c.Get(".todo", &todo); err == nil {
+		t.Errorf("Get() returned %v for a deleted element", todo)
+	}
+	buf, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "todo: !delete\n"; strings.TrimSpace(string(buf)) != strings.TrimSpace(want) {
+		t.Errorf("Got file content %q, want %q", buf, want)
+	}
+	e, err := c.Explain(".todo")
+	if err != nil {
+		t.Fatalf("Explain() failed: %v", err)
+	}
+	if e.Found || e.Winner != -1 || e.Layers[1].ShadowedBy != 0 {
+		t.Errorf("Got explanation %+v, want element shadowed by layer 0", e)
+	}
+
+	// Reset makes the default visible again.
+	if err := c.Reset(".todo"); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	if err := c.Get(".todo", &todo); err != nil || !reflect.DeepEqual(todo, []string{"a", "b"}) {
+		t.Errorf("Get() returned %v (%v), want %v", todo, err, []string{"a", "b"})
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var audit []string
+	c, err := New([]Storage{UseDummyStorage("", nil), UseDummyStorage(".server.port", 80)},
+		WithBeforeSet(func(path string, value interface{}) (interface{}, error) {
+			if path != ".server.port" {
+				return value, nil
+			}
+			port, ok := value.(tree.Number)
+			if !ok {
+				return nil, fmt.Errorf("port has to be a number")
+			}
+			if v, _ := port.Int64(); v < 1024 {
+				return nil, fmt.Errorf("port %d is not allowed", v)
+			}
+			return value, nil
+		}),
+		WithBeforeSet(func(path string, value interface{}) (interface{}, error) {
+			if s, ok := value.(string); ok {
+				return strings.TrimSpace(s), nil
+			}
+			return value, nil
+		}),
+		WithBeforeReset(func(path string) error {
+			if path == ".server.port" {
+				return fmt.Errorf("port can't be reset")
+			}
+			return nil
+		}),
+		WithAfterWrite(func(e ChangeEvent) {
+			for _, change := range append(append(e.Modified, e.Added...), e.Removed...) {
+				audit = append(audit, fmt.Sprintf("%s: %v -> %v", change.Path, change.Old, change.New))
+			}
+		}),
+		WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Set(".server.port", 80); err == nil {
+		t.Errorf("Set() didn't fail for a rejected value")
+	} else if _, ok := err.(*ErrRejected); !ok {
+		t.Errorf("Set() returned %v, want rejected error", err)
+	}
+	if err := c.Reset(".server.port"); err == nil {
+		t.Errorf("Reset() didn't fail for a rejected path")
+	}
+
+	if err := c.Set(".server.port", 8080); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Set(".server.host", "  example.com "); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	var host string
+	if err := c.Get(".server.host", &host); err != nil || host != "example.com" {
+		t.Errorf("Got host %q (%v), want %q", host, err, "example.com")
+	}
+
+	want := []string{".server.port: 80 -> 8080", ".server.host: <nil> -> example.com"}
+	if !reflect.DeepEqual(audit, want) {
+		t.Errorf("Got audit log %q, want %q", audit, want)
+	}
+}
+
+func TestEventCallback(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage(".server", tree.Node{"port": 80, "host": "localhost"})}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	eventChan := make(chan ChangeEvent, 10)
+	c.RegisterEventCallback([]string{".server.port"}, func(c *Config, e ChangeEvent) {
+		eventChan <- e
+	})
+
+	// The initial event contains the current value.
+	e := <-eventChan
+	if want := []Change{{Path: ".server.port", New: tree.Number("80")}}; !reflect.DeepEqual(e.Added, want) {
+		t.Errorf("Got added %v, want %v", e.Added, want)
+	}
+
+	if err := c.Set(".server.host", "example.com"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Set(".server.port", 8080); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	// Changes outside of the registered paths are filtered.
+	e = <-eventChan
+	if want := []Change{{Path: ".server.port", Old: tree.Number("80"), New: tree.Number("8080")}}; !reflect.DeepEqual(e.Modified, want) {
+		t.Errorf("Got modified %v, want %v", e.Modified, want)
+	}
+	if len(e.Added) != 0 || len(e.Removed) != 0 {
+		t.Errorf("Got added %v and removed %v, want nothing", e.Added, e.Removed)
+	}
+	if v := e.Old.GetInt64(".server.port", 0); v != 80 {
+		t.Errorf("Got old tree with port %d, want %d", v, 80)
+	}
+	if v := e.New.GetString(".server.host", ""); v != "example.com" {
+		t.Errorf("Got new tree with host %q, want %q", v, "example.com")
+	}
+}
+
+func TestWildcards(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage(".services", tree.Node{
+		"web": tree.Node{"port": 80, "host": "localhost"},
+		"db":  tree.Node{"port": 5432},
+	})}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	matches, err := c.GetMatches(".services.*.port")
+	if err != nil {
+		t.Fatalf("GetMatches() failed: %v", err)
+	}
+	want := map[string]interface{}{".services.web.port": tree.Number("80"), ".services.db.port": tree.Number("5432")}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("Got matches %v, want %v", matches, want)
+	}
+
+	pathChan := make(chan []string, 10)
+	c.RegisterCallback([]string{".services.*.port"}, func(c *Config, modified, added, removed []string) {
+		pathChan <- append(append(modified, added...), removed...)
+	})
+	if got := <-pathChan; len(got) != 2 {
+		t.Errorf("Got initial paths %v, want 2 paths", got)
+	}
+
+	if err := c.Set(".services.web.host", "example.com"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Set(".services.db.port", 5433); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if got, want := <-pathChan, []string{".services.db.port"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got paths %v, want %v", got, want)
+	}
+}
+
+func TestCallbackIsolation(t *testing.T) {
+	errChan := make(chan error, 10)
+	c, err := New([]Storage{UseDummyStorage("", nil)}, WithSetWaitsForReload(), WithCallbackTimeout(50*time.Millisecond), WithErrorHandler(func(err error) {
+		errChan <- err
+	}))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	// A callback that blocks until it's released.
+	releaseChan := make(chan struct{})
+	c.RegisterCallback([]string{".blocking"}, func(c *Config, modified, added, removed []string) {
+		<-releaseChan
+	})
+
+	// A callback that panics on the first call.
+	valueChan := make(chan int64, 10)
+	c.RegisterEventCallback([]string{".value"}, func(c *Config, e ChangeEvent) {
+		v := e.New.GetInt64(".value", 0)
+		if v == 1 {
+			panic("test")
+		}
+		valueChan <- v
+	})
+
+	// A callback that writes with SetWaitsForReload enabled.
+	c.RegisterCallback([]string{".trigger"}, func(c *Config, modified, added, removed []string) {
+		if err := c.Set(".value", 2); err != nil {
+			t.Errorf("Set() failed: %v", err)
+		}
+	})
+
+	if err := c.Set(".blocking", true); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Set(".value", 1); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Set(".trigger", true); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	select {
+	case v := <-valueChan:
+		if v != 2 {
+			t.Errorf("Got value %d, want %d", v, 2)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Callback wasn't called")
+	}
+
+	var gotPanic, gotTimeout bool
+	for !gotPanic || !gotTimeout {
+		select {
+		case err := <-errChan:
+			switch err.(type) {
+			case *ErrCallbackPanic:
+				gotPanic = true
+			case *ErrCallbackTimeout:
+				gotTimeout = true
+			default:
+				t.Errorf("Got unexpected error %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Got panic error %v and timeout error %v, want both", gotPanic, gotTimeout)
+		}
+	}
+
+	close(releaseChan)
+}
+
+func TestCallbackQueueLimit(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage("", nil)}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	// A callback that blocks on its first call, until it's released.
+	const sets = 4 * maxListenerQueue
+	releaseChan := make(chan struct{})
+	eventChan := make(chan ChangeEvent, sets+1)
+	c.RegisterEventCallback([]string{".value"}, func(c *Config, e ChangeEvent) {
+		<-releaseChan
+		eventChan <- e
+	})
+
+	for i := 1; i <= sets; i++ {
+		if err := c.Set(".value", i); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+	close(releaseChan)
+
+	// Queued events are combined, but together they still describe every change in order.
+	var events int
+	var last int64
+	for last != sets {
+		select {
+		case e := <-eventChan:
+			events++
+			if old := e.Old.GetInt64(".value", 0); old != last {
+				t.Errorf("Event starts at value %d, want %d", old, last)
+			}
+			last = e.New.GetInt64(".value", 0)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Got %d events up to value %d, want value %d", events, last, sets)
+		}
+	}
+	if events > maxListenerQueue+2 {
+		t.Errorf("Got %d events, want at most %d", events, maxListenerQueue+2)
+	}
+}
+
+// closerStorage is a storage that counts how often it got closed.
+type closerStorage struct {
+	Storage
+	closed int
+}
+
+func (s *closerStorage) Close() error {
+	s.closed++
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	storage := &closerStorage{Storage: UseDummyStorage("", nil)}
+	c, err := New([]Storage{storage})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	called := make(chan struct{}, 10)
+	id := c.RegisterCallback(nil, func(c *Config, modified, added, removed []string) {
+		called <- struct{}{}
+	})
+	if err := c.Set(".a", 1); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Second Close() failed: %v", err)
+	}
+	if storage.closed != 1 {
+		t.Errorf("Storage got closed %d times, want %d", storage.closed, 1)
+	}
+
+	isErrClosed := func(name string, err error) {
+		t.Helper()
+		if _, ok := err.(*ErrClosed); !ok {
+			t.Errorf("%s returned %v, want ErrClosed", name, err)
+		}
+	}
+	isErrClosed("Set()", c.Set(".a", 2))
+	isErrClosed("Reset()", c.Reset(".a"))
+	isErrClosed("Delete()", c.Delete(".a"))
+	isErrClosed("Reload()", c.Reload())
+	isErrClosed("Get()", c.Get(".a", new(int)))
+	_, err = c.Explain(".a")
+	isErrClosed("Explain()", err)
+	_, err = c.Analyze()
+	isErrClosed("Analyze()", err)
+
+	if id := c.RegisterCallback(nil, func(c *Config, modified, added, removed []string) {}); id != -1 {
+		t.Errorf("RegisterCallback() returned %d, want %d", id, -1)
+	}
+	c.UnregisterCallback(id)
+
+	if _, ok := <-c.Watch(context.Background()); ok {
+		t.Errorf("Watch() returned a channel that isn't closed")
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage("", nil)}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	startedChan, releaseChan := make(chan struct{}), make(chan struct{})
+	c.RegisterCallback(nil, func(c *Config, modified, added, removed []string) {
+		close(startedChan)
+		<-releaseChan
+	})
+	if err := c.Set(".a", 1); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	<-startedChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("CloseContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(releaseChan)
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+}
+
+// hangingStorage is a storage whose context variants block until the context is done, while hang is set.
+type hangingStorage struct {
+	Storage
+	hang int32 // Is accessed atomically.
+}
+
+func (s *hangingStorage) ReadContext(ctx context.Context) (tree.Node, error) {
+	if atomic.LoadInt32(&s.hang) != 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.Read()
+}
+
+func (s *hangingStorage) WriteContext(ctx context.Context, t tree.Node) error {
+	if atomic.LoadInt32(&s.hang) != 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.Write(t)
+}
+
+func TestContext(t *testing.T) {
+	storage := &hangingStorage{Storage: UseDummyStorage("", nil)}
+	c, err := New([]Storage{Named("hanging", storage)}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetContext(context.Background(), ".a", 1); err != nil {
+		t.Fatalf("SetContext() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.SetContext(ctx, ".a", 2); err != context.Canceled {
+		t.Errorf("SetContext() with cancelled context returned %v, want %v", err, context.Canceled)
+	}
+	if id, err := c.RegisterCallbackContext(context.Background(), nil, func(c *Config, modified, added, removed []string) {}); err != nil || id < 0 {
+		t.Errorf("RegisterCallbackContext() returned %v, %v", id, err)
+	}
+
+	atomic.StoreInt32(&storage.hang, 1)
+	isDeadlineExceeded := func(name string, f func(ctx context.Context) error) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := f(ctx); err != context.DeadlineExceeded {
+			t.Errorf("%s returned %v, want %v", name, err, context.DeadlineExceeded)
+		}
+	}
+	isDeadlineExceeded("SetContext()", func(ctx context.Context) error { return c.SetContext(ctx, ".a", 3) })
+	isDeadlineExceeded("ResetContext()", func(ctx context.Context) error { return c.ResetContext(ctx, ".a") })
+	isDeadlineExceeded("SetInContext()", func(ctx context.Context) error { return c.SetInContext(ctx, "hanging", ".a", 3) })
+	isDeadlineExceeded("DeleteContext()", func(ctx context.Context) error { return c.DeleteContext(ctx, ".a") })
+	isDeadlineExceeded("ResetInContext()", func(ctx context.Context) error { return c.ResetInContext(ctx, "hanging", ".a") })
+	isDeadlineExceeded("ReloadContext()", c.ReloadContext)
+	isDeadlineExceeded("GetFromContext()", func(ctx context.Context) error { return c.GetFromContext(ctx, "hanging", ".a", new(int)) })
+	atomic.StoreInt32(&storage.hang, 0)
+
+	var a int
+	if err := c.Get(".a", &a); err != nil || a != 1 {
+		t.Errorf("Get() returned %v, %v, want %v, %v", a, err, 1, nil)
+	}
+}
+
+// countingStorage is a storage that counts its reads, and whose changes can be signaled manually.
+type countingStorage struct {
+	Storage
+	reads      int32 // Is accessed atomically.
+	changeChan chan<- struct{}
+}
+
+func (s *countingStorage) Read() (tree.Node, error) {
+	atomic.AddInt32(&s.reads, 1)
+	return s.Storage.Read()
+}
+
+func (s *countingStorage) RegisterWatcher(changeChan chan<- struct{}) error {
+	s.changeChan = changeChan
+	return nil
+}
+
+func TestIncrementalReload(t *testing.T) {
+	a := &countingStorage{Storage: UseDummyStorage(".a", 1)}
+	b := &countingStorage{Storage: UseDummyStorage(".b", 2)}
+	c, err := New([]Storage{a, b}, WithDebounce(0))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	// Only the changed storage is read again.
+	b.Storage.Write(tree.Node{"b": tree.Number("3")})
+	b.changeChan <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitForGeneration(ctx, 2); err != nil {
+		t.Fatalf("WaitForGeneration() failed: %v", err)
+	}
+
+	if reads := atomic.LoadInt32(&a.reads); reads != 1 {
+		t.Errorf("Unchanged storage got read %d times, want %d", reads, 1)
+	}
+	if reads := atomic.LoadInt32(&b.reads); reads != 2 {
+		t.Errorf("Changed storage got read %d times, want %d", reads, 2)
+	}
+	var v int
+	if err := c.Get(".b", &v); err != nil || v != 3 {
+		t.Errorf("Get() returned %v, %v, want %v, %v", v, err, 3, nil)
+	}
+
+	// Reload() reads all storages.
+	if err := c.Reload(); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if reads := atomic.LoadInt32(&a.reads); reads != 2 {
+		t.Errorf("Storage got read %d times after Reload(), want %d", reads, 2)
+	}
+}
+
+func TestIncrementalReload_Write(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage(".a", 1)}, WithoutWatchers())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	// Written storages are read back, even without watchers.
+	if err := c.Set(".a", 2); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		var v int
+		if err := c.Get(".a", &v); err == nil && v == 2 {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("Written value didn't become visible")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// failingStorage is a storage whose reads fail, as long as fail is set.
+type failingStorage struct {
+	Storage
+	fail int32 // Is accessed atomically.
+}
+
+func (s *failingStorage) Read() (tree.Node, error) {
+	if atomic.LoadInt32(&s.fail) != 0 {
+		return nil, fmt.Errorf("storage is broken")
+	}
+	return s.Storage.Read()
+}
+
+func TestIncrementalReload_SkippedLayer(t *testing.T) {
+	a := &failingStorage{Storage: UseDummyStorage(".a", 1), fail: 1}
+	b := &countingStorage{Storage: UseDummyStorage(".b", 2)}
+	c, err := New([]Storage{Optional(a), b}, WithDebounce(0), WithErrorHandler(func(err error) {}))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Get(".a", new(int)); err == nil {
+		t.Errorf("Get() succeeded, but the optional storage should have been skipped")
+	}
+
+	// Once it's fixed, the skipped storage is read again with the next change of any other storage.
+	atomic.StoreInt32(&a.fail, 0)
+	b.changeChan <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitForGeneration(ctx, 2); err != nil {
+		t.Fatalf("WaitForGeneration() failed: %v", err)
+	}
+
+	var v int
+	if err := c.Get(".a", &v); err != nil || v != 1 {
+		t.Errorf("Get() returned %v, %v, want %v, %v", v, err, 1, nil)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// Directory represents a directory on disk, where every file is a single key.
+//
+// This is the layout Kubernetes uses to project ConfigMaps and Secrets into a container.
+type Directory struct {
+	path string
+
+	pollInterval time.Duration
+	watcher      *watcher
+
+	warnings []error // Problems of the last read, see Warnings().
+}
+
+// UseDirectory returns a Directory object.
+//
+// Every file inside the directory becomes an element of the tree, with the file name as its key.
+// Files ending in ".json", ".yaml" or ".yml" are parsed, and their extension is removed from the key.
+// The content of any other file is used as string.
+// Subdirectories become nodes.
+//
+// Periods inside a key nest the element, e.g. the file "tls.crt" of a TLS secret becomes ".tls.crt".
+// Entries that collide with another entry, like the files "tls" and "tls.crt", are skipped and reported as ErrEntrySkipped through the error handler, see WithErrorHandler().
+//
+// Entries starting with a period are ignored silently, this includes the "..data" symlink and the timestamped directories Kubernetes uses.
+//
+// The storage is read-only, any write will fail.
+func UseDirectory(path string) Storage {
+	d := &Directory{
+		path:    path,
+		watcher: nil,
+	}
+
+	return d
+}
+
+// Read returns the tree representation of its content.
+func (d *Directory) Read() (tree.Node, error) {
+	d.warnings = nil
+
+	if _, err := os.Stat(d.path); os.IsNotExist(err) {
+		return tree.Node{}, nil // Not existent directory behaves like an empty tree.
+	}
+
+	return d.readDir(d.path)
+}
+
+// Warnings returns the entries that were skipped by the last read, and the errors that occurred while watching for changes.
+func (d *Directory) Warnings() []error {
+	warnings := append(d.warnings, d.watcher.takeErrors()...)
+	d.warnings = nil
+	return warnings
+}
+
+func (d *Directory) readDir(dirPath string) (tree.Node, error) {
+	infos, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory %v failed: %w", dirPath, err)
+	}
+
+	node := tree.Node{}
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		entryPath := filepath.Join(dirPath, name)
+
+		// Use os.Stat to follow symlinks.
+		info, err := os.Stat(entryPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %v failed: %w", entryPath, err)
+		}
+
+		if info.IsDir() {
+			child, err := d.readDir(entryPath)
+			if err != nil {
+				return nil, err
+			}
+			d.setEntry(node, entryPath, name, child)
+			continue
+		}
+
+		key, value, err := readKeyFile(entryPath)
+		if err != nil {
+			return nil, err
+		}
+		d.setEntry(node, entryPath, key, value)
+	}
+
+	return node, nil
+}
+
+// setEntry stores the value of an entry at the path of its key, periods inside the key nest the value.
+// Entries that can't be stored are skipped, and reported with the next call of Warnings().
+func (d *Directory) setEntry(node tree.Node, entryPath, key string, value interface{}) {
+	path := tree.PathJoin("", key)
+
+	var err error
+	elements := tree.PathSplit(path)
+	for i, element := range elements[1:] {
+		if element == "" {
+			err = fmt.Errorf("the key contains an empty path element")
+			break
+		}
+		// Every parent has to be a node, and the element itself must not exist yet.
+		v, ok := node.Lookup(tree.PathJoin(elements[:i+2]...))
+		if !ok {
+			break
+		}
+		if _, isNode := v.(tree.Node); !isNode || i+2 == len(elements) {
+			err = fmt.Errorf("there is already an element at %q", tree.PathJoin(elements[:i+2]...))
+			break
+		}
+	}
+	if err == nil {
+		err = node.Set(path, value)
+	}
+
+	if err != nil {
+		d.warnings = append(d.warnings, &ErrEntrySkipped{entryPath, key, err})
+	}
+}
+
+// readKeyFile reads a single file, and returns its key and its parsed value.
+func readKeyFile(filePath string) (key string, value interface{}, err error) {
+	buf, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading file %v failed: %w", filePath, err)
+	}
+
+	name := filepath.Base(filePath)
+	ext := filepath.Ext(name)
+
+	switch strings.ToLower(ext) {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(buf))
+		d.UseNumber()
+		if err := d.Decode(&value); err != nil {
+			return "", nil, fmt.Errorf("unmarshalling %v failed: %w", filePath, err)
+		}
+		return strings.TrimSuffix(name, ext), value, nil
+
+	case ".yaml", ".yml":
+		value, err := tree.UnmarshalYAMLElement(buf)
+		if err != nil {
+			return "", nil, fmt.Errorf("unmarshalling %v failed: %w", filePath, err)
+		}
+		return strings.TrimSuffix(name, ext), value, nil
+	}
+
+	return name, string(buf), nil
+}
+
+// Exists returns whether the directory exists.
+func (d *Directory) Exists() (bool, error) {
+	if _, err := os.Stat(d.path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Write takes a tree and stores it in some shape and form.
+//
+// Directories are read-only, so this will always fail.
+func (d *Directory) Write(t tree.Node) error {
+	return fmt.Errorf("directory %v is read-only", d.path)
+}
+
+// dirs returns the directory itself and all its subdirectories that are part of the tree.
+func (d *Directory) dirs() []string {
+	result := []string{d.path}
+
+	var recursive func(dirPath string)
+	recursive = func(dirPath string) {
+		infos, err := ioutil.ReadDir(dirPath)
+		if err != nil {
+			return
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if strings.HasPrefix(name, ".") || strings.Contains(name, tree.PathSeparator) {
+				continue
+			}
+			entryPath := filepath.Join(dirPath, name)
+			if info, err := os.Stat(entryPath); err == nil && info.IsDir() {
+				result = append(result, entryPath)
+				recursive(entryPath)
+			}
+		}
+	}
+	recursive(d.path)
+
+	return result
+}
+
+// RegisterWatcher takes a channel that is used to signal changes/modifications of the data.
+// Only one channel can be registered at a time.
+//
+// The directory and all its subdirectories are watched.
+// Symlinks that are swapped atomically, like Kubernetes's "..data" symlink, are handled too.
+// If filesystem events are not available, or if a poll interval is set, the directories are polled instead.
+//
+// A nil value can be passed to unregister the listener.
+func (d *Directory) RegisterWatcher(changeChan chan<- struct{}) error {
+	// Close previous element, if there is one.
+	if d.watcher != nil {
+		err := d.watcher.Close()
+		if err != nil {
+			return err
+		}
+		d.watcher = nil
+	}
+
+	// If there is no channel, just do nothing.
+	if changeChan == nil {
+		return nil
+	}
+
+	d.watcher = newDirWatcher(d.dirs, d.pollInterval, changeChan)
+
+	return nil
+}
+
+// SetPollInterval sets the interval in which the directory is checked for changes.
+// An interval of 0 disables polling, in this case filesystem events are used.
+//
+// This has to be called before RegisterWatcher().
+func (d *Directory) SetPollInterval(interval time.Duration) {
+	d.pollInterval = interval
+}
+
+// PollInterval returns the interval in which the directory is checked for changes, or 0 if filesystem events are used.
+func (d *Directory) PollInterval() time.Duration {
+	return d.pollInterval
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// writeConfigMap writes files into a new timestamped directory, and swaps the "..data" symlink to it.
+// This mimics the way Kubernetes updates ConfigMap and Secret volumes.
+func writeConfigMap(t *testing.T, dir, version string, files map[string]string) {
+	dataDir := filepath.Join(dir, "..data_"+version)
+	for name, content := range files {
+		filePath := filepath.Join(dataDir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Create the top level symlink for every key, if it doesn't exist yet.
+		topName := strings.SplitN(name, "/", 2)[0]
+		linkPath := filepath.Join(dir, topName)
+		if _, err := os.Lstat(linkPath); os.IsNotExist(err) {
+			if err := os.Symlink(filepath.Join("..data", topName), linkPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tempLink := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(dataDir), tempLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tempLink, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"port":          "8080",
+		"server.json":   `{"host": "localhost", "timeout": 10}`,
+		"http.yml":      "enabled: true\n",
+		"sub/key":       "value",
+		"tls.crt":       "certificate",
+		"tls.key":       "private key",
+		"port.tls":      "collides with port",
+		".hidden":       "hidden",
+		"sub/list.yaml": "- a\n- b\n",
+	}
+	writeConfigMap(t, dir, "1", files)
+
+	var skippedMutex sync.Mutex
+	var skipped []string
+	c, err := New([]Storage{UseDirectory(dir)}, WithErrorHandler(func(err error) {
+		if err, ok := err.(*ErrEntrySkipped); ok {
+			skippedMutex.Lock()
+			skipped = append(skipped, err.Key)
+			skippedMutex.Unlock()
+		}
+	}))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	// Entries that collide with other entries are reported.
+	skippedMutex.Lock()
+	if !reflect.DeepEqual(skipped, []string{"port.tls"}) {
+		t.Errorf("Skipped entries %v, want %v", skipped, []string{"port.tls"})
+	}
+	skippedMutex.Unlock()
+
+	want := tree.Node{
+		"port": "8080",
+		"server": tree.Node{
+			"host":    "localhost",
+			"timeout": tree.Number("10"),
+		},
+		"http": tree.Node{
+			"enabled": true,
+		},
+		"tls": tree.Node{
+			"crt": "certificate",
+			"key": "private key",
+		},
+		"sub": tree.Node{
+			"key":  "value",
+			"list": []interface{}{"a", "b"},
+		},
+	}
+
+	var got tree.Node
+	if err := c.Get("", &got); err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// Swap the "..data" symlink, and wait for the change to arrive.
+	files["port"], files["sub/key"] = "9090", "changed"
+	writeConfigMap(t, dir, "2", files)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		var port, key string
+		c.Get(".port", &port)
+		c.Get(".sub.key", &key)
+		if port == "9090" && key == "changed" {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("Change wasn't detected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestDirectory_Tombstones(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultsPath := filepath.Join(dir, "defaults.json")
+	if err := ioutil.WriteFile(defaultsPath, []byte(`{"tls": {"enabled": true, "cert": "a.pem"}, "server": {"host": "localhost", "port": 80}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both formats remove elements of lower priority storages in the same way.
+	dataDir := filepath.Join(dir, "data")
+	writeConfigMap(t, dataDir, "1", map[string]string{
+		"tls.yaml":    "cert: !delete\n",
+		"server.json": `{"port": {"$delete": true}}`,
+	})
+
+	c, err := New([]Storage{UseDirectory(dataDir), UseJSONFile(defaultsPath)})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	want := tree.Node{
+		"tls": tree.Node{
+			"enabled": true,
+		},
+		"server": tree.Node{
+			"host": "localhost",
+		},
+	}
+
+	var got tree.Node
+	if err := c.Get("", &got); err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
@@ -37,7 +37,7 @@ func UseDummyStorage(initPath string, initData interface{}) Storage {
 
 // Read returns the tree representation of its content.
 func (f *DummyStorage) Read() (tree.Node, error) {
-	return f.tree, nil
+	return f.tree.Copy(), nil // Return a copy, so that modifications of the result don't change the storage.
 }
 
 // Write takes a tree and stores it in some shape and form.
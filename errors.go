@@ -0,0 +1,137 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrValidation is returned if the validator rejected a tree.
+type ErrValidation struct {
+	Err error
+}
+
+func (e *ErrValidation) Error() string {
+	return fmt.Sprintf("validation failed: %v", e.Err)
+}
+
+func (e *ErrValidation) Unwrap() error {
+	return e.Err
+}
+
+// ErrClosed is returned by all methods of a Config that has been closed.
+type ErrClosed struct{}
+
+func (e *ErrClosed) Error() string {
+	return "config is closed"
+}
+
+// ErrLayerMissing is returned if the storage of a required layer doesn't exist.
+type ErrLayerMissing struct {
+	Index int
+	Name  string
+}
+
+func (e *ErrLayerMissing) Error() string {
+	return fmt.Sprintf("storage of %v doesn't exist", layerDescription(e.Index, e.Name))
+}
+
+// ErrLayerSkipped is reported as a warning if an optional layer couldn't be read.
+type ErrLayerSkipped struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *ErrLayerSkipped) Error() string {
+	return fmt.Sprintf("skipped optional %v: %v", layerDescription(e.Index, e.Name), e.Err)
+}
+
+func (e *ErrLayerSkipped) Unwrap() error {
+	return e.Err
+}
+
+// ErrEntrySkipped is reported as a warning if a storage ignored an entry, because it couldn't be stored in the tree.
+type ErrEntrySkipped struct {
+	Path string // Path of the entry in the storage, e.g. a file path.
+	Key  string
+	Err  error
+}
+
+func (e *ErrEntrySkipped) Error() string {
+	return fmt.Sprintf("skipped %v with the key %q: %v", e.Path, e.Key, e.Err)
+}
+
+func (e *ErrEntrySkipped) Unwrap() error {
+	return e.Err
+}
+
+// ErrLayerNotFound is returned if there is no layer with the given name.
+type ErrLayerNotFound struct {
+	Name string
+}
+
+func (e *ErrLayerNotFound) Error() string {
+	return fmt.Sprintf("there is no layer with the name %q", e.Name)
+}
+
+// layerDescription returns a human readable description of a layer.
+func layerDescription(index int, name string) string {
+	if name != "" {
+		return fmt.Sprintf("layer %q", name)
+	}
+	return fmt.Sprintf("layer at index %d", index)
+}
+
+// ErrPathLocked is returned if a write would modify a path that is locked by another layer.
+type ErrPathLocked struct {
+	Path  string // The path that was written to.
+	Lock  string // The locked path.
+	Index int    // Index of the layer that locks the path.
+	Name  string // Name of the layer that locks the path.
+}
+
+func (e *ErrPathLocked) Error() string {
+	return fmt.Sprintf("path %v is locked by %v", e.Lock, layerDescription(e.Index, e.Name))
+}
+
+// ErrRejected is returned if a hook rejected a write.
+type ErrRejected struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrRejected) Error() string {
+	return fmt.Sprintf("write to %v rejected: %v", e.Path, e.Err)
+}
+
+func (e *ErrRejected) Unwrap() error {
+	return e.Err
+}
+
+// ErrCallbackPanic is reported if a callback panicked.
+// The panic is recovered, and the callback is called again with the next change.
+type ErrCallbackPanic struct {
+	ID    int         // ID of the callback, as returned by RegisterCallback().
+	Value interface{} // The value that was passed to panic().
+	Stack []byte      // The stack trace of the panic.
+}
+
+func (e *ErrCallbackPanic) Error() string {
+	return fmt.Sprintf("callback %d panicked: %v", e.ID, e.Value)
+}
+
+// ErrCallbackTimeout is reported if a callback didn't return within the time defined by WithCallbackTimeout().
+// The call is not cancelled, further changes are delivered to the callback once it returns.
+type ErrCallbackTimeout struct {
+	ID      int // ID of the callback, as returned by RegisterCallback().
+	Timeout time.Duration
+}
+
+func (e *ErrCallbackTimeout) Error() string {
+	return fmt.Sprintf("callback %d didn't return within %v", e.ID, e.Timeout)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"github.com/Dadido3/D3config/tree"
+)
+
+// Change describes a single element that was modified, added or removed.
+type Change struct {
+	Path string
+	Old  interface{} // The element before the change, nil if it was added.
+	New  interface{} // The element after the change, nil if it was removed.
+}
+
+// ChangeEvent describes a change of the merged tree.
+// Use RegisterEventCallback() to receive change events.
+//
+// The elements and trees are shared with other listeners, they must not be modified.
+// Use tree.Marshal() or Node.Copy() to get a copy.
+type ChangeEvent struct {
+	Modified, Added, Removed []Change
+
+	Old, New tree.Node // The complete merged trees before and after the change.
+}
+
+// newChangeEvent compares both trees, and returns the event that describes the changes.
+func newChangeEvent(old, new tree.Node) ChangeEvent {
+	modified, added, removed := old.Compare(new)
+
+	return ChangeEvent{
+		Modified: changes(modified, old, new),
+		Added:    changes(added, old, new),
+		Removed:  changes(removed, old, new),
+		Old:      old,
+		New:      new,
+	}
+}
+
+// changes returns the elements of both trees at the given paths.
+func changes(paths []string, old, new tree.Node) []Change {
+	result := make([]Change, 0, len(paths))
+	for _, path := range paths {
+		c := Change{Path: path}
+		c.Old, _ = old.Lookup(path)
+		c.New, _ = new.Lookup(path)
+		result = append(result, c)
+	}
+	return result
+}
+
+// filter returns the event with only the changes at or inside any of the given paths.
+// The paths can be patterns, see tree.PathMatch().
+func (e ChangeEvent) filter(paths ...string) ChangeEvent {
+	filter := func(changes []Change) []Change {
+		result := []Change{}
+		for _, c := range changes {
+			for _, path := range paths {
+				if tree.PathContainsMatch(c.Path, path) {
+					result = append(result, c)
+					break
+				}
+			}
+		}
+		return result
+	}
+
+	e.Modified, e.Added, e.Removed = filter(e.Modified), filter(e.Added), filter(e.Removed)
+	return e
+}
+
+// empty returns whether the event doesn't contain any changes.
+func (e ChangeEvent) empty() bool {
+	return len(e.Modified) == 0 && len(e.Added) == 0 && len(e.Removed) == 0
+}
+
+// changePaths returns the paths of the given changes.
+func changePaths(changes []Change) []string {
+	result := make([]string, 0, len(changes))
+	for _, c := range changes {
+		result = append(result, c.Path)
+	}
+	return result
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"github.com/Dadido3/D3config/tree"
+)
+
+// LayerValue is what a single layer contributes to the value at some path.
+type LayerValue struct {
+	Index int    // Index of the layer in the list of storages.
+	Name  string // Name of the layer, if it has one.
+
+	Value interface{} // Copy of the value at the path inside this layer.
+	Found bool        // Whether the layer contains the path.
+
+	// ShadowedBy is the index of the higher priority layer that hides the value of this layer, or -1.
+	// This can also be a layer that deletes the path with a tree.Tombstone.
+	// A layer can also be shadowed by a value at a parent path, like a higher priority layer that contains a string where this layer has a node.
+	ShadowedBy int
+}
+
+// Explanation describes where the value at some path comes from.
+// Use Config.Explain() to get it.
+type Explanation struct {
+	Path  string
+	Value interface{} // Copy of the merged value.
+	Found bool        // Whether the merged tree contains the path.
+
+	// Layers contains the contribution of every layer, in the same order as the storages that were passed to New().
+	Layers []LayerValue
+
+	// Winner is the index of the highest priority layer whose value is visible at the path, or -1 if the path doesn't exist.
+	Winner int
+
+	// Merged contains the indices of lower priority layers whose nodes or slices are merged into the value of the winner, see WithMergeRule().
+	Merged []int
+
+	// Shadowed contains the indices of all layers whose value is completely hidden by higher priority layers.
+	Shadowed []int
+}
+
+// Explain returns the value at the given path, together with the values every single layer contributes to it.
+// It shows which layer won the merge, and which layers were shadowed.
+//
+// The result describes the current tree, it's not updated on changes.
+func (c *Config) Explain(path string) (Explanation, error) {
+	if c.isClosed() {
+		return Explanation{}, &ErrClosed{}
+	}
+
+	return c.snapshot().Explain(path)
+}
+
+// explain simulates the merge of the layer trees at the given path, see Config.Explain().
+func explain(layers []*Layer, layerTrees []tree.Node, merged tree.Node, rules tree.MergeRules, path string) (Explanation, error) {
+	elements := tree.PathSplit(path)
+	if elements[0] != "" {
+		return Explanation{}, &tree.ErrPathInvalid{Path: path, Reason: "First path element has to be empty"}
+	}
+
+	e := Explanation{
+		Path:   path,
+		Layers: make([]LayerValue, len(layerTrees)),
+		Winner: -1,
+	}
+
+	if v, ok := merged.Lookup(path); ok {
+		e.Value, _ = tree.Marshal(v)
+		e.Found = true
+	}
+
+	// Go from the lowest to the highest priority, like the merge does.
+	var contributors []int // Layers whose value is visible at the moment, lowest priority first.
+	for i := len(layerTrees) - 1; i >= 0; i-- {
+		lv := LayerValue{Index: i, ShadowedBy: -1}
+		if i < len(layers) {
+			lv.Name = layers[i].Name
+		}
+
+		v, found, blocked := lookupLayerValue(layerTrees[i], elements, rules)
+		if found {
+			lv.Value, _ = tree.Marshal(v)
+			lv.Found = true
+		}
+		e.Layers[i] = lv
+
+		// Nodes are merged into nodes, and slices may be merged according to the rules. Anything else replaces what was visible before.
+		if blocked || found && !(len(contributors) > 0 && mergesInto(e.Layers[contributors[len(contributors)-1]].Value, v, rules[path])) {
+			for _, j := range contributors {
+				e.Layers[j].ShadowedBy = i
+			}
+			contributors = nil
+		}
+		if found && !isTombstoneValue(v) {
+			contributors = append(contributors, i)
+		}
+	}
+
+	// A lock of any layer overrides the result of the merge.
+	if lockIndex, _ := lockedBy(layers, layerTrees, path); lockIndex >= 0 {
+		contributors = nil
+		for i := range e.Layers {
+			lv := &e.Layers[i]
+			if i == lockIndex {
+				lv.ShadowedBy = -1
+				if lv.Found && !isTombstoneValue(lv.Value) {
+					contributors = []int{i}
+				}
+			} else if lv.Found {
+				lv.ShadowedBy = lockIndex
+			}
+		}
+	}
+
+	if len(contributors) > 0 {
+		e.Winner = contributors[len(contributors)-1]
+		for i := len(contributors) - 2; i >= 0; i-- {
+			e.Merged = append(e.Merged, contributors[i])
+		}
+	}
+	for _, lv := range e.Layers {
+		if lv.ShadowedBy >= 0 {
+			e.Shadowed = append(e.Shadowed, lv.Index)
+		}
+	}
+
+	return e, nil
+}
+
+// lookupLayerValue returns the element at the path given by its elements.
+// blocked is true if the layer replaces a whole parent of the path.
+// That's the case if any parent is not a node, or if a parent node is merged with MergeReplaceNode.
+func lookupLayerValue(t tree.Node, elements []string, rules tree.MergeRules) (v interface{}, found, blocked bool) {
+	inter := interface{}(t)
+	for i, e := range elements[1:] {
+		node, ok := inter.(tree.Node)
+		if !ok {
+			return nil, false, true
+		}
+		if i > 0 && rules[tree.PathJoin(elements[:i+1]...)].Mode == tree.MergeReplaceNode {
+			blocked = true
+		}
+		if inter, ok = node[e]; !ok {
+			return nil, false, blocked
+		}
+	}
+	return inter, true, blocked
+}
+
+// isTombstoneValue returns whether the given tree element is a deletion marker.
+func isTombstoneValue(v interface{}) bool {
+	_, ok := v.(tree.Tombstone)
+	return ok
+}
+
+// mergesInto returns whether the new element is merged into the old one, instead of replacing it.
+func mergesInto(old, new interface{}, rule tree.MergeRule) bool {
+	switch old.(type) {
+	case tree.Node:
+		return isNodeValue(new) && rule.Mode != tree.MergeReplaceNode
+	case []interface{}:
+		_, ok := new.([]interface{})
+		return ok && (rule.Mode == tree.MergeAppend || rule.Mode == tree.MergePrepend || rule.Mode == tree.MergeByKey)
+	}
+	return false
+}
+
+// isNodeValue returns whether the given tree element is a node.
+func isNodeValue(v interface{}) bool {
+	_, ok := v.(tree.Node)
+	return ok
+}
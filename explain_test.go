@@ -0,0 +1,72 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+func TestExplain(t *testing.T) {
+	c, err := New([]Storage{
+		Named("user", UseDummyStorage(".server", tree.Node{"port": 9090})),
+		Named("override", UseDummyStorage(".server", tree.Node{"port": 8081, "host": "example.com"})),
+		Named("defaults", UseDummyStorage(".server", tree.Node{"port": 8080, "host": "localhost", "tls": tree.Node{"enabled": false}})),
+		Named("broken", UseDummyStorage(".server", "foo")),
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		path         string
+		wantValue    interface{}
+		wantWinner   int
+		wantMerged   []int
+		wantShadowed []int
+	}{
+		{".server.port", tree.Number("9090"), 0, nil, []int{1, 2}},
+		{".server.host", "example.com", 1, nil, []int{2}},
+		{".server.tls.enabled", false, 2, nil, nil},
+		{".server", tree.Node{"port": tree.Number("9090"), "host": "example.com", "tls": tree.Node{"enabled": false}}, 0, []int{1, 2}, []int{3}},
+		{".missing", nil, -1, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			e, err := c.Explain(tt.path)
+			if err != nil {
+				t.Fatalf("Explain() failed: %v", err)
+			}
+			if !reflect.DeepEqual(e.Value, tt.wantValue) {
+				t.Errorf("Got value %v, want %v", e.Value, tt.wantValue)
+			}
+			if e.Winner != tt.wantWinner {
+				t.Errorf("Got winner %d, want %d", e.Winner, tt.wantWinner)
+			}
+			if !reflect.DeepEqual(e.Merged, tt.wantMerged) {
+				t.Errorf("Got merged layers %v, want %v", e.Merged, tt.wantMerged)
+			}
+			if !reflect.DeepEqual(e.Shadowed, tt.wantShadowed) {
+				t.Errorf("Got shadowed layers %v, want %v", e.Shadowed, tt.wantShadowed)
+			}
+			if len(e.Layers) != 4 || e.Layers[1].Name != "override" {
+				t.Errorf("Got layers %v, want 4 layers with names", e.Layers)
+			}
+		})
+	}
+
+	// The layer with the string at ".server" is shadowed by the node of a higher priority layer.
+	e, err := c.Explain(".server")
+	if err != nil {
+		t.Fatalf("Explain() failed: %v", err)
+	}
+	if lv := e.Layers[3]; !lv.Found || lv.Value != "foo" || lv.ShadowedBy != 2 {
+		t.Errorf("Got %+v for the lowest layer, want value %q shadowed by layer 2", lv, "foo")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"github.com/Dadido3/D3config/tree"
+)
+
+// listenerIndex is a trie of the path patterns of all listeners.
+//
+// It's used to find all listeners that are interested in a path in a single walk over the path's elements, instead of comparing the path against the patterns of every listener.
+// Wildcard elements are stored as normal children, and are only treated differently when the index is searched.
+type listenerIndex struct {
+	children  map[string]*listenerIndex
+	listeners []*listener // Listeners with a pattern that ends at this node.
+}
+
+// add inserts the listener for all of its paths.
+func (idx *listenerIndex) add(l *listener) {
+	for _, path := range l.paths {
+		n := idx
+		for _, element := range tree.PathSplit(path) {
+			child, ok := n.children[element]
+			if !ok {
+				if n.children == nil {
+					n.children = make(map[string]*listenerIndex)
+				}
+				child = &listenerIndex{}
+				n.children[element] = child
+			}
+			n = child
+		}
+		n.listeners = append(n.listeners, l)
+	}
+}
+
+// remove deletes the listener from all of its paths, and prunes nodes that aren't used anymore.
+func (idx *listenerIndex) remove(l *listener) {
+	for _, path := range l.paths {
+		idx.removePath(l, tree.PathSplit(path))
+	}
+}
+
+// removePath deletes the listener from the node at the given elements.
+// It returns whether the node is empty afterwards.
+func (idx *listenerIndex) removePath(l *listener, elements []string) bool {
+	if len(elements) == 0 {
+		for i, listener := range idx.listeners {
+			if listener == l {
+				idx.listeners = append(idx.listeners[:i], idx.listeners[i+1:]...)
+				break
+			}
+		}
+	} else if child, ok := idx.children[elements[0]]; ok && child.removePath(l, elements[1:]) {
+		delete(idx.children, elements[0])
+	}
+
+	return len(idx.listeners) == 0 && len(idx.children) == 0
+}
+
+// match adds all listeners to result that have a pattern that matches the given path elements or any of its parents.
+// This is the equivalent of tree.PathContainsMatch() for every pattern in the index.
+func (idx *listenerIndex) match(elements []string, result map[*listener]struct{}) {
+	for _, l := range idx.listeners {
+		result[l] = struct{}{}
+	}
+
+	if len(elements) > 0 {
+		if child, ok := idx.children[elements[0]]; ok {
+			child.match(elements[1:], result)
+		}
+		if child, ok := idx.children[tree.PathWildcard]; ok && elements[0] != tree.PathWildcard {
+			child.match(elements[1:], result)
+		}
+	}
+
+	if child, ok := idx.children[tree.PathWildcardAnyDepth]; ok {
+		for i := 0; i <= len(elements); i++ {
+			child.match(elements[i:], result)
+		}
+	}
+}
+
+// dispatch splits the event into one filtered event per interested listener.
+// Every change is contained at most once in the event of a listener, even if several of its paths match.
+func (idx *listenerIndex) dispatch(e ChangeEvent) map[*listener]ChangeEvent {
+	events := make(map[*listener]ChangeEvent)
+	matches := make(map[*listener]struct{})
+
+	add := func(changes []Change, get func(e *ChangeEvent) *[]Change) {
+		for _, change := range changes {
+			idx.match(tree.PathSplit(change.Path), matches)
+			for l := range matches {
+				le, ok := events[l]
+				if !ok {
+					le.Old, le.New = e.Old, e.New
+				}
+				list := get(&le)
+				*list = append(*list, change)
+				events[l] = le
+				delete(matches, l)
+			}
+		}
+	}
+
+	add(e.Modified, func(e *ChangeEvent) *[]Change { return &e.Modified })
+	add(e.Added, func(e *ChangeEvent) *[]Change { return &e.Added })
+	add(e.Removed, func(e *ChangeEvent) *[]Change { return &e.Removed })
+
+	return events
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+func TestListenerIndex(t *testing.T) {
+	patterns := []string{"", ".a", ".a.b", ".a.*", ".a.*.c", ".**", ".**.c", ".a.**", ".a.**.c", ".x.*", ".x.y.z"}
+	paths := []string{".a", ".a.b", ".a.b.c", ".a.d.c", ".a.b.d.c", ".b", ".b.c", ".x", ".x.y", ".x.y.z", ".x.y.z.w"}
+
+	index := &listenerIndex{}
+	listeners := make([]*listener, len(patterns))
+	for i, pattern := range patterns {
+		listeners[i] = &listener{id: i, paths: []string{pattern}}
+		index.add(listeners[i])
+	}
+
+	for _, path := range paths {
+		result := make(map[*listener]struct{})
+		index.match(tree.PathSplit(path), result)
+		for i, pattern := range patterns {
+			_, got := result[listeners[i]]
+			if want := tree.PathContainsMatch(path, pattern); got != want {
+				t.Errorf("match(%q) contains listener with pattern %q = %v, want %v", path, pattern, got, want)
+			}
+		}
+	}
+
+	// Remove all listeners again, the index has to be empty afterwards.
+	for _, l := range listeners {
+		index.remove(l)
+	}
+	if len(index.children) != 0 || len(index.listeners) != 0 {
+		t.Errorf("Index isn't empty after removing all listeners: %v", index)
+	}
+}
+
+func TestListenerIndex_Dispatch(t *testing.T) {
+	index := &listenerIndex{}
+	l1 := &listener{id: 1, paths: []string{".a", ".a.b", ".**.b"}} // Overlapping paths.
+	l2 := &listener{id: 2, paths: []string{".c"}}
+	l3 := &listener{id: 3, paths: []string{".d"}}
+	index.add(l1)
+	index.add(l2)
+	index.add(l3)
+
+	event := ChangeEvent{
+		Modified: []Change{{Path: ".a.b"}, {Path: ".c"}},
+		Added:    []Change{{Path: ".a.e"}},
+		Removed:  []Change{{Path: ".x.b"}},
+	}
+
+	events := index.dispatch(event)
+
+	want := map[*listener]ChangeEvent{
+		l1: {
+			Modified: []Change{{Path: ".a.b"}},
+			Added:    []Change{{Path: ".a.e"}},
+			Removed:  []Change{{Path: ".x.b"}},
+		},
+		l2: {
+			Modified: []Change{{Path: ".c"}},
+		},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("dispatch() = %v, want %v", events, want)
+	}
+}
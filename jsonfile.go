@@ -10,16 +10,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/Dadido3/D3config/tree"
-	"github.com/fsnotify/fsnotify"
 )
 
 // JSONFile represents a json file on disk.
 type JSONFile struct {
 	path string
 
-	watcher *fsnotify.Watcher
+	pollInterval time.Duration
+	watcher      *watcher
 }
 
 // UseJSONFile returns a JSONFile object.
@@ -51,6 +52,17 @@ func (f *JSONFile) Read() (tree.Node, error) {
 	return node, nil
 }
 
+// Exists returns whether the file exists.
+func (f *JSONFile) Exists() (bool, error) {
+	if _, err := os.Stat(f.path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // Write takes a tree and stores it in some shape and form.
 func (f *JSONFile) Write(t tree.Node) error {
 	buf, err := json.MarshalIndent(t, "", "    ")
@@ -73,6 +85,9 @@ func (f *JSONFile) Write(t tree.Node) error {
 // RegisterWatcher takes a channel that is used to signal changes/modifications of the data.
 // Only one channel can be registered at a time.
 //
+// The directory containing the file is watched, so the watcher keeps working when the file is replaced, removed or created later on.
+// If filesystem events are not available, or if a poll interval is set, the file is polled instead.
+//
 // A nil value can be passed to unregister the listener.
 func (f *JSONFile) RegisterWatcher(changeChan chan<- struct{}) error {
 	// Close previous element, if there is one.
@@ -89,39 +104,25 @@ func (f *JSONFile) RegisterWatcher(changeChan chan<- struct{}) error {
 		return nil
 	}
 
-	w, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
-	}
+	f.watcher = newFileWatcher(f.path, f.pollInterval, changeChan)
 
-	go func(w *fsnotify.Watcher) {
-		for {
-			select {
-			case _, ok := <-w.Events:
-				if !ok {
-					return
-				}
-				// Write to changeChan in a non blocking way.
-				select {
-				case changeChan <- struct{}{}:
-				default:
-				}
-			case _, ok := <-w.Errors:
-				if !ok {
-					return
-				}
-				// TODO: Handle error
-			}
-		}
-	}(w)
+	return nil
+}
 
-	err = w.Add(f.path)
-	if err != nil {
-		w.Close()
-		return err
-	}
+// Warnings returns the errors that occurred while watching for changes since the last call.
+func (f *JSONFile) Warnings() []error {
+	return f.watcher.takeErrors()
+}
 
-	f.watcher = w
+// SetPollInterval sets the interval in which the file is checked for changes.
+// An interval of 0 disables polling, in this case filesystem events are used.
+//
+// This has to be called before RegisterWatcher().
+func (f *JSONFile) SetPollInterval(interval time.Duration) {
+	f.pollInterval = interval
+}
 
-	return nil
+// PollInterval returns the interval in which the file is checked for changes, or 0 if filesystem events are used.
+func (f *JSONFile) PollInterval() time.Duration {
+	return f.pollInterval
 }
@@ -0,0 +1,125 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"context"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// Layer wraps a storage object, and defines how it is treated by a Config object.
+//
+// A Layer implements the Storage interface itself, so it can be used in the list of storages that is passed to New():
+//
+//	storages := []config.Storage{
+//		config.Optional(config.UseJSONFile("/etc/myapp/override.json")),
+//		config.Named("user", config.UseJSONFile("~/.config/myapp/config.json")),
+//		config.Required(config.UseJSONFile("/etc/myapp/config.json")),
+//	}
+//
+// Storages that are not wrapped in a Layer are treated like a Layer with all fields set to their zero value.
+type Layer struct {
+	Storage
+
+	// Name is used to address the layer, see Config.SetIn(), Config.ResetIn() and Config.GetFrom().
+	// Names have to be unique, but they are optional.
+	Name string
+
+	// Optional layers can be missing or broken.
+	// If an optional layer can't be read, it is skipped and a warning is reported.
+	Optional bool
+
+	// Required layers have to exist.
+	// If the storage of a required layer doesn't exist, it's a hard error.
+	// This only works with storages that implement the ExistenceChecker interface, other storages are considered to exist.
+	//
+	// Without this, a missing file behaves like an empty one.
+	Required bool
+
+	// Locked contains paths whose value is enforced by this layer, regardless of its priority.
+	// Layers with a higher priority can't override the elements at these paths.
+	// Set(), Reset() and Delete() fail with ErrPathLocked when they would modify a locked path in any other layer.
+	//
+	// A lock has no effect as long as the layer doesn't contain the path.
+	Locked []string
+}
+
+// Named returns the storage wrapped in a Layer with the given name.
+func Named(name string, storage Storage) *Layer {
+	l := asLayer(storage)
+	l.Name = name
+	return l
+}
+
+// Optional returns the storage wrapped in a Layer that is marked as optional.
+func Optional(storage Storage) *Layer {
+	l := asLayer(storage)
+	l.Optional = true
+	return l
+}
+
+// Required returns the storage wrapped in a Layer that is marked as required.
+func Required(storage Storage) *Layer {
+	l := asLayer(storage)
+	l.Required = true
+	return l
+}
+
+// Locked returns the storage wrapped in a Layer that enforces its values at the given paths, see Layer.Locked.
+func Locked(storage Storage, paths ...string) *Layer {
+	l := asLayer(storage)
+	l.Locked = append(append([]string{}, l.Locked...), paths...)
+	return l
+}
+
+// asLayer returns a copy of the given storage, if it is a Layer.
+// Otherwise the storage is wrapped into a new Layer.
+func asLayer(storage Storage) *Layer {
+	if l, ok := storage.(*Layer); ok {
+		copy := *l
+		return &copy
+	}
+	return &Layer{Storage: storage}
+}
+
+// read returns the tree of the layer.
+// If the layer is optional and couldn't be read, a warning is reported, and an empty tree is returned with skipped set to true.
+// Errors are never skipped if the context is done.
+func (l *Layer) read(ctx context.Context, c *Config, index int) (t tree.Node, skipped bool, err error) {
+	if l.Required || l.Optional {
+		if checker, ok := l.Storage.(ExistenceChecker); ok {
+			exists, err := checker.Exists()
+			if err == nil && !exists {
+				err = &ErrLayerMissing{index, l.Name}
+			}
+			if err != nil {
+				if l.Optional && ctx.Err() == nil {
+					c.reportError(&ErrLayerSkipped{index, l.Name, err})
+					return tree.Node{}, true, nil
+				}
+				return nil, false, err
+			}
+		}
+	}
+
+	t, err = readStorage(ctx, l.Storage)
+	if err != nil {
+		if l.Optional && ctx.Err() == nil {
+			c.reportError(&ErrLayerSkipped{index, l.Name, err})
+			return tree.Node{}, true, nil
+		}
+		return nil, false, err
+	}
+
+	if r, ok := l.Storage.(WarningReporter); ok {
+		for _, warning := range r.Warnings() {
+			c.reportError(warning)
+		}
+	}
+
+	return t, false, nil
+}
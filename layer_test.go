@@ -0,0 +1,237 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+func TestLayerOptionalRequired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	missingPath := filepath.Join(dir, "missing.json")
+	brokenPath := filepath.Join(dir, "broken.json")
+	if err := ioutil.WriteFile(brokenPath, []byte(`{"a": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing required layer is a hard error.
+	if _, err := New([]Storage{Required(UseJSONFile(missingPath))}); err == nil {
+		t.Errorf("New() didn't fail for a missing required layer")
+	} else if _, ok := err.(*ErrLayerMissing); !ok {
+		t.Errorf("New() returned %v, want missing layer error", err)
+	}
+
+	// A broken layer is a hard error by default.
+	if _, err := New([]Storage{UseJSONFile(brokenPath)}); err == nil {
+		t.Errorf("New() didn't fail for a broken layer")
+	}
+
+	// Missing or broken optional layers are skipped with a warning.
+	var warnings []error
+	c, err := New([]Storage{
+		Optional(UseJSONFile(missingPath)),
+		Optional(UseJSONFile(brokenPath)),
+		Optional(&testStorage{readErr: fmt.Errorf("remote storage not reachable")}),
+		UseDummyStorage(".value", 123),
+	}, WithErrorHandler(func(err error) {
+		warnings = append(warnings, err)
+	}))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if len(warnings) != 3 {
+		t.Errorf("Got %d warnings, want %d", len(warnings), 3)
+	}
+	for _, warning := range warnings {
+		if _, ok := warning.(*ErrLayerSkipped); !ok {
+			t.Errorf("Got warning %v, want skipped layer error", warning)
+		}
+	}
+
+	var result tree.Node
+	if err := c.Get("", &result); err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if v := result.GetInt64(".value", 0); v != 123 {
+		t.Errorf("Got value %d, want %d", v, 123)
+	}
+}
+
+func TestLayerNamed(t *testing.T) {
+	c, err := New([]Storage{
+		Named("user", UseDummyStorage("", nil)),
+		Named("system", UseDummyStorage(".setting", "default")),
+	}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetIn("system", ".machine", "foo"); err != nil {
+		t.Fatalf("SetIn() failed: %v", err)
+	}
+	if err := c.SetIn("user", ".setting", "custom"); err != nil {
+		t.Fatalf("SetIn() failed: %v", err)
+	}
+
+	var s string
+	if err := c.GetFrom("system", ".machine", &s); err != nil || s != "foo" {
+		t.Errorf("GetFrom() returned %q (%v), want %q", s, err, "foo")
+	}
+	if err := c.GetFrom("system", ".setting", &s); err != nil || s != "default" {
+		t.Errorf("GetFrom() returned %q (%v), want %q", s, err, "default")
+	}
+	if err := c.GetFrom("user", ".machine", &s); err == nil {
+		t.Errorf("GetFrom() didn't fail for a value that is not inside the layer")
+	}
+	if err := c.Get(".setting", &s); err != nil || s != "custom" {
+		t.Errorf("Get() returned %q (%v), want %q", s, err, "custom")
+	}
+
+	if err := c.ResetIn("user", ".setting"); err != nil {
+		t.Fatalf("ResetIn() failed: %v", err)
+	}
+	if err := c.Get(".setting", &s); err != nil || s != "default" {
+		t.Errorf("Get() returned %q (%v), want %q", s, err, "default")
+	}
+
+	if err := c.SetIn("unknown", ".foo", 123); err == nil {
+		t.Errorf("SetIn() didn't fail for an unknown layer")
+	} else if _, ok := err.(*ErrLayerNotFound); !ok {
+		t.Errorf("SetIn() returned %v, want layer not found error", err)
+	}
+
+	// Names have to be unique.
+	if _, err := New([]Storage{Named("a", UseDummyStorage("", nil)), Named("a", UseDummyStorage("", nil))}); err == nil {
+		t.Errorf("New() didn't fail for duplicate layer names")
+	}
+}
+
+func TestWriteRoute(t *testing.T) {
+	c, err := New([]Storage{
+		Named("user", UseDummyStorage("", nil)),
+		Named("secrets", UseDummyStorage("", nil)),
+	}, WithWriteRoute(".credentials", "secrets"), WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	type Credentials struct {
+		User     string `conf:"user"`
+		Password string `conf:"password"`
+	}
+	settings := struct {
+		Name        string      `conf:"name"`
+		Credentials Credentials `conf:"credentials"`
+	}{"foo", Credentials{"admin", "1234"}}
+
+	// Parts of the object are routed into different layers.
+	if err := c.Set("", settings); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Set(".credentials.password", "5678"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	var user, secrets tree.Node
+	if err := c.GetFrom("user", "", &user); err != nil {
+		t.Fatalf("GetFrom() failed: %v", err)
+	}
+	if err := c.GetFrom("secrets", "", &secrets); err != nil {
+		t.Fatalf("GetFrom() failed: %v", err)
+	}
+	wantUser := tree.Node{"name": "foo"}
+	wantSecrets := tree.Node{"credentials": tree.Node{"user": "admin", "password": "5678"}}
+	if !reflect.DeepEqual(user, wantUser) {
+		t.Errorf("Got user layer %v, want %v", user, wantUser)
+	}
+	if !reflect.DeepEqual(secrets, wantSecrets) {
+		t.Errorf("Got secrets layer %v, want %v", secrets, wantSecrets)
+	}
+
+	// Resetting a parent path resets the routed paths too.
+	if err := c.Reset(""); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	var merged tree.Node
+	if err := c.Get("", &merged); err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if len(merged) != 0 {
+		t.Errorf("Got %v after Reset(), want empty tree", merged)
+	}
+
+	// Routes have to point to existing layers.
+	if _, err := New([]Storage{UseDummyStorage("", nil)}, WithWriteRoute(".foo", "unknown")); err == nil {
+		t.Errorf("New() didn't fail for a route to an unknown layer")
+	}
+}
+
+func TestLayerLocked(t *testing.T) {
+	c, err := New([]Storage{
+		Named("user", UseDummyStorage(".telemetry", tree.Node{"enabled": true, "interval": 10})),
+		Locked(Named("policy", UseDummyStorage(".telemetry.enabled", false)), ".telemetry.enabled", ".missing"),
+	}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	var enabled bool
+	if err := c.Get(".telemetry.enabled", &enabled); err != nil || enabled {
+		t.Errorf("Got %v (%v), want %v", enabled, err, false)
+	}
+	e, err := c.Explain(".telemetry.enabled")
+	if err != nil {
+		t.Fatalf("Explain() failed: %v", err)
+	}
+	if e.Winner != 1 || e.Layers[0].ShadowedBy != 1 {
+		t.Errorf("Got explanation %+v, want winner 1 that shadows layer 0", e)
+	}
+
+	// Writes to the locked path fail.
+	for name, err := range map[string]error{
+		"Set()":     c.Set(".telemetry.enabled", true),
+		"Reset()":   c.Reset(".telemetry.enabled"),
+		"Delete()":  c.Delete(".telemetry.enabled"),
+		"Set(\"\")": c.Set("", map[string]interface{}{"telemetry": map[string]bool{"enabled": true}}),
+	} {
+		if _, ok := err.(*ErrPathLocked); !ok {
+			t.Errorf("%s returned %v, want path locked error", name, err)
+		}
+	}
+
+	// Other paths can be written, even if they are next to or the parent of a locked path.
+	if err := c.Set(".telemetry.interval", 20); err != nil {
+		t.Errorf("Set() failed: %v", err)
+	}
+	if err := c.Set("", map[string]interface{}{"telemetry": map[string]int{"interval": 30}}); err != nil {
+		t.Errorf("Set() failed: %v", err)
+	}
+	if err := c.SetIn("policy", ".telemetry.enabled", true); err != nil {
+		t.Errorf("SetIn() failed: %v", err)
+	}
+
+	// Locks of paths that don't exist in their layer have no effect.
+	if err := c.Set(".missing", 123); err != nil {
+		t.Errorf("Set() failed: %v", err)
+	}
+}
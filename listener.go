@@ -0,0 +1,153 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// maxListenerQueue is the number of events a listener queues, before they are combined into a single event.
+const maxListenerQueue = 16
+
+// listener delivers change events to a single callback.
+//
+// Every listener has its own goroutine and queue, so a slow callback doesn't delay any other listener.
+// Events are delivered in order, one at a time.
+// If a callback falls behind by more than maxListenerQueue events, the queued events are combined into one.
+type listener struct {
+	id            int
+	paths         []string
+	callback      func(c *Config, modified, added, removed []string)
+	eventCallback func(c *Config, e ChangeEvent) // Is used instead of callback, if it's not nil.
+
+	queueMutex sync.Mutex
+	queue      []ChangeEvent
+
+	stopMutex sync.Mutex // Is held from checking stopped until a call starts, so no call starts once stop() returned.
+	stopped   bool
+
+	signalChan chan struct{} // Signals that there is something in the queue.
+	stopChan   chan struct{} // Is closed to stop the listener.
+	doneChan   chan struct{} // Is closed once the goroutine stopped, and the callback isn't called anymore.
+}
+
+// newListener returns a listener with a running goroutine that delivers events to the callback.
+func newListener(c *Config, id int, paths []string, callback func(c *Config, modified, added, removed []string), eventCallback func(c *Config, e ChangeEvent)) *listener {
+	if len(paths) == 0 {
+		paths = []string{""} // Add at least one empty path that fits all, if there are not paths defined.
+	}
+
+	l := &listener{
+		id:            id,
+		paths:         paths,
+		callback:      callback,
+		eventCallback: eventCallback,
+		signalChan:    make(chan struct{}, 1),
+		stopChan:      make(chan struct{}),
+		doneChan:      make(chan struct{}),
+	}
+
+	go l.run(c)
+
+	return l
+}
+
+// push adds the event to the queue. This is non blocking.
+// The event has to be filtered for the listener's paths already.
+//
+// If the queue is full, all queued events and the new one are combined into a single event.
+// Nothing is queued if the changes cancel each other out.
+func (l *listener) push(e ChangeEvent) {
+	l.queueMutex.Lock()
+	if len(l.queue) >= maxListenerQueue {
+		e = newChangeEvent(l.queue[0].Old, e.New).filter(l.paths...)
+		l.queue = nil
+	}
+	if !e.empty() {
+		l.queue = append(l.queue, e)
+	}
+	l.queueMutex.Unlock()
+
+	select {
+	case l.signalChan <- struct{}{}:
+	default:
+	}
+}
+
+// stop makes the goroutine stop after the current call. Queued events are dropped.
+// Once this returns, no new call is started.
+func (l *listener) stop() {
+	l.stopMutex.Lock()
+	l.stopped = true
+	l.stopMutex.Unlock()
+
+	close(l.stopChan)
+}
+
+func (l *listener) run(c *Config) {
+	defer close(l.doneChan)
+
+	for {
+		select {
+		case <-l.stopChan:
+			return
+		case <-l.signalChan:
+		}
+
+		for {
+			l.queueMutex.Lock()
+			if len(l.queue) == 0 {
+				l.queueMutex.Unlock()
+				break
+			}
+			e := l.queue[0]
+			l.queue[0] = ChangeEvent{} // Don't keep a reference to the trees.
+			l.queue = l.queue[1:]
+			l.queueMutex.Unlock()
+
+			if !l.call(c, e) {
+				return
+			}
+		}
+	}
+}
+
+// call passes the event to the callback, and returns whether it did.
+// It returns false without calling the callback, if the listener is stopped.
+// Panics are recovered and reported, and calls that take longer than the callback timeout are reported too.
+func (l *listener) call(c *Config, e ChangeEvent) (called bool) {
+	// Hold the lock until the callback starts, this way stop() either happens before the check, or after the call started.
+	l.stopMutex.Lock()
+	if l.stopped {
+		l.stopMutex.Unlock()
+		return false
+	}
+
+	if timeout := c.options.CallbackTimeout; timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			c.reportError(&ErrCallbackTimeout{l.id, timeout})
+		})
+		defer timer.Stop()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.reportError(&ErrCallbackPanic{l.id, r, debug.Stack()})
+		}
+	}()
+
+	called = true
+	l.stopMutex.Unlock()
+
+	if l.eventCallback != nil {
+		l.eventCallback(c, e)
+	} else {
+		l.callback(c, changePaths(e.Modified), changePaths(e.Added), changePaths(e.Removed))
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"github.com/Dadido3/D3config/tree"
+)
+
+// checkLockPaths returns an error if any locked path of the given layers is invalid.
+func checkLockPaths(layers []*Layer) error {
+	for _, layer := range layers {
+		for _, path := range layer.Locked {
+			if tree.PathSplit(path)[0] != "" {
+				return &tree.ErrPathInvalid{Path: path, Reason: "First path element has to be empty"}
+			}
+		}
+	}
+	return nil
+}
+
+// enforceLocks overwrites all locked paths of the merged tree with the values of their layers.
+// Layers are processed from the lowest to the highest priority, so the highest priority lock wins.
+func enforceLocks(layers []*Layer, trees []tree.Node, merged *tree.Builder) {
+	for i := len(trees) - 1; i >= 0; i-- {
+		for _, path := range layers[i].Locked {
+			v, ok := trees[i].Lookup(path)
+			if !ok {
+				continue
+			}
+
+			// Replace the element by removing it first, and merging the locked value afterwards.
+			// Merging takes care of tombstones.
+			merged.Remove(path) // Fails if the parent doesn't exist or is not a node, which is fine.
+			patch := tree.Node{}
+			if path == "" {
+				node, _ := tree.Marshal(v)
+				patch = node.(tree.Node)
+			} else if err := patch.Set(path, v); err != nil {
+				continue
+			}
+			merged.MergeWithRules(patch, nil)
+		}
+	}
+}
+
+// lockedBy returns the index of the highest priority layer that has a lock containing the given path, and the locked path.
+// If the path isn't locked, -1 is returned.
+func lockedBy(layers []*Layer, trees []tree.Node, path string) (int, string) {
+	for i, layer := range layers {
+		for _, lockPath := range layer.Locked {
+			if !tree.PathContains(path, lockPath) {
+				continue
+			}
+			if i < len(trees) {
+				if _, ok := trees[i].Lookup(lockPath); !ok {
+					continue
+				}
+			}
+			return i, lockPath
+		}
+	}
+	return -1, ""
+}
+
+// checkLocks returns an ErrPathLocked error if any of the operations would modify a path that is locked by another layer.
+//
+// Modifications of a parent of a locked path are allowed, as long as they don't contain the locked path.
+func (c *Config) checkLocks(ops []writeOp) error {
+	layerTrees := c.snapshot().layerTrees
+
+	for _, op := range ops {
+		for i, layer := range c.layers {
+			if i == op.layer {
+				continue
+			}
+			for _, lockPath := range layer.Locked {
+				if _, ok := layerTrees[i].Lookup(lockPath); !ok {
+					continue // The lock is not active.
+				}
+				locked := tree.PathContains(op.path, lockPath)
+				if !locked && !op.reset && tree.PathContains(lockPath, op.path) {
+					// The operation writes a parent of the locked path, check if the value contains the locked path.
+					if node, ok := op.value.(tree.Node); ok {
+						_, locked = node.Lookup(lockPath[len(op.path):])
+					}
+				}
+				if locked {
+					return &ErrPathLocked{Path: op.path, Lock: lockPath, Index: i, Name: layer.Name}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// Logger is used to output errors and warnings.
+// It is implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger writes into the standard logger of the log package.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// Options contains all settings of a Config object.
+//
+// Use DefaultOptions() to get an Options object with sane defaults, and pass it to NewWithOptions().
+// Alternatively, pass any Option functions to New().
+type Options struct {
+	// Debounce is the time that has to pass without any further storage change before the configuration is reloaded.
+	// This way a single save operation, which may cause several change events, results in exactly one reload.
+	// A value of 0 disables debouncing, any change will reload the configuration immediately.
+	Debounce time.Duration
+
+	// RetryMin and RetryMax define the time to wait before a failed reload is retried.
+	// The wait time starts at RetryMin and is doubled with every further failure, up to RetryMax.
+	// Any new storage change resets the wait time.
+	// Failed reloads are only reported if the first retry fails too, so transient errors of half written files are not reported.
+	// A RetryMin value of 0 disables retries, failed reloads are reported immediately.
+	// A RetryMax value smaller than RetryMin is treated as RetryMin.
+	RetryMin, RetryMax time.Duration
+
+	// PollInterval enables polling with the given interval for all storages that support it, see PollingStorage.
+	// Storages that already have a poll interval set are not modified.
+	// A value of 0 doesn't change the storages.
+	PollInterval time.Duration
+
+	// DisableWatchers disables the automatic reload on storage changes.
+	// The configuration is only reloaded by calling Reload(), or by receiving one of the ReloadSignals.
+	DisableWatchers bool
+
+	// ReloadSignals is a list of signals that cause a reload of the configuration.
+	ReloadSignals []os.Signal
+
+	// Logger is used to output errors, if there is no ErrorHandler.
+	Logger Logger
+
+	// ErrorHandler is called with any error that occurs in the background, like failed reloads.
+	// If it's nil, errors are written to the Logger.
+	//
+	// The handler is called from internal goroutines, it must not call any methods of the Config object.
+	ErrorHandler func(err error)
+
+	// Validator is called with every new merged tree.
+	// If it returns an error, the tree is rejected and the previous one is kept.
+	// This also applies to Set() and Reset(), which will not write anything if the result is rejected.
+	//
+	// The validator must not modify the tree.
+	Validator func(t tree.Node) error
+
+	// SetWaitsForReload makes Set() and Reset() wait until their change is visible in the tree.
+	// Otherwise a Get() directly following a Set() may still result in old data.
+	SetWaitsForReload bool
+
+	// CallbackTimeout is the time a callback can take before an ErrCallbackTimeout is reported.
+	// The callback is not cancelled, the error is just a warning.
+	// A value of 0 disables the timeout.
+	CallbackTimeout time.Duration
+
+	// WriteRoutes define which layer Set() and Reset() write into, depending on the path.
+	// The deepest matching route wins, without any match the layer at index 0 is used.
+	WriteRoutes []WriteRoute
+
+	// MergeRules define how the elements at specific paths are merged, see tree.MergeRules.
+	// By default, nodes are merged recursively and anything else is replaced by higher priority layers.
+	MergeRules tree.MergeRules
+
+	// BeforeSet hooks are called with every element that is about to be written by Set(), SetIn() or Delete().
+	// They can return a different value that is written instead, or an error to reject the write.
+	// See WithBeforeSet().
+	BeforeSet []func(path string, value interface{}) (interface{}, error)
+
+	// BeforeReset hooks are called with every path that is about to be reset by Reset() or ResetIn().
+	// They can return an error to reject the reset.
+	BeforeReset []func(path string) error
+
+	// AfterWrite hooks are called with the changes of the merged tree after every successful write.
+	// See WithAfterWrite().
+	AfterWrite []func(e ChangeEvent)
+}
+
+// DefaultOptions returns the options that are used if nothing else is defined.
+func DefaultOptions() Options {
+	return Options{
+		Debounce: 50 * time.Millisecond,
+		RetryMin: 100 * time.Millisecond,
+		RetryMax: 30 * time.Second,
+		Logger:   stdLogger{},
+	}
+}
+
+// Option changes the behavior of a Config object.
+// Options can be passed to New().
+type Option func(*Options)
+
+// WithOptions replaces all options with the given ones.
+func WithOptions(options Options) Option {
+	return func(o *Options) {
+		*o = options
+	}
+}
+
+// WithDebounce sets the time that has to pass without any further storage change before the configuration is reloaded.
+// This way a single save operation, which may cause several change events, results in exactly one reload.
+//
+// A value of 0 disables debouncing, any change will reload the configuration immediately.
+// The default is 50 ms.
+func WithDebounce(d time.Duration) Option {
+	return func(o *Options) {
+		o.Debounce = d
+	}
+}
+
+// WithRetryBackoff sets the time to wait before a failed reload is retried.
+// The wait time starts at min and is doubled with every further failure, up to max.
+// Any new storage change resets the wait time.
+//
+// Failed reloads are only reported if the first retry fails too, so transient errors of half written files are not reported.
+//
+// A min value of 0 disables retries, failed reloads are reported immediately.
+// A max value smaller than min is treated as min.
+// The default is 100 ms to 30 s.
+func WithRetryBackoff(min, max time.Duration) Option {
+	return func(o *Options) {
+		o.RetryMin, o.RetryMax = min, max
+	}
+}
+
+// WithPolling enables polling with the given interval for all storages that support it, see PollingStorage.
+// Storages that already have a poll interval set are not modified.
+//
+// Use this if the configuration is stored on a filesystem that doesn't support filesystem events.
+// Use Polling() instead, to enable polling for single storages.
+func WithPolling(interval time.Duration) Option {
+	return func(o *Options) {
+		o.PollInterval = interval
+	}
+}
+
+// WithoutWatchers disables the automatic reload on storage changes.
+// The configuration is only reloaded by calling Reload(), or by receiving a signal defined with WithReloadSignal().
+func WithoutWatchers() Option {
+	return func(o *Options) {
+		o.DisableWatchers = true
+	}
+}
+
+// WithReloadSignal reloads the configuration whenever the process receives one of the given signals.
+// If no signal is given, SIGHUP is used.
+func WithReloadSignal(signals ...os.Signal) Option {
+	return func(o *Options) {
+		if len(signals) == 0 {
+			signals = []os.Signal{syscall.SIGHUP}
+		}
+		o.ReloadSignals = signals
+	}
+}
+
+// WithLogger sets the logger that errors are written to, if there is no error handler.
+// By default, errors are written to the standard logger of the log package.
+func WithLogger(logger Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithErrorHandler sets a function that is called with any error that occurs in the background, like failed reloads.
+// Errors are not logged if there is an error handler.
+//
+// The handler is called from internal goroutines, it must not call any methods of the Config object.
+func WithErrorHandler(handler func(err error)) Option {
+	return func(o *Options) {
+		o.ErrorHandler = handler
+	}
+}
+
+// WithValidator sets a function that is called with every new merged tree.
+// If it returns an error, the tree is rejected and the previous one is kept.
+// This also applies to Set() and Reset(), which will not write anything if the result is rejected.
+//
+// The validator must not modify the tree.
+func WithValidator(validator func(t tree.Node) error) Option {
+	return func(o *Options) {
+		o.Validator = validator
+	}
+}
+
+// WithSetWaitsForReload makes Set() and Reset() wait until their change is visible in the tree.
+func WithSetWaitsForReload() Option {
+	return func(o *Options) {
+		o.SetWaitsForReload = true
+	}
+}
+
+// WithCallbackTimeout reports an ErrCallbackTimeout whenever a callback takes longer than the given time.
+// The callback is not cancelled, and other callbacks are not affected by it.
+func WithCallbackTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.CallbackTimeout = timeout
+	}
+}
+
+// WithWriteRoute makes Set() and Reset() write anything at or inside path into the layer with the given name.
+// Use Named() to give layers a name.
+//
+// Writes that don't match any route go to the layer at index 0.
+// If several routes match, the one with the deepest path wins.
+// The routes are also applied to parts of objects that are written to a parent path:
+//
+//	config.WithWriteRoute(".credentials", "secrets")
+//
+// With this route, c.Set("", settings) writes the "credentials" field of settings into the "secrets" layer, and everything else into the layer at index 0.
+func WithWriteRoute(path, layer string) Option {
+	return func(o *Options) {
+		o.WriteRoutes = append(o.WriteRoutes, WriteRoute{path, layer})
+	}
+}
+
+// WithMergeRule sets how the element at the given path is merged when the storages are merged into one tree.
+// This can be used to append slices of all storages, or to merge slices of nodes by a key:
+//
+//	config.WithMergeRule(".plugins", tree.MergeRule{Mode: tree.MergeAppend})
+//	config.WithMergeRule(".servers", tree.MergeRule{Mode: tree.MergeByKey, Key: "name"})
+func WithMergeRule(path string, rule tree.MergeRule) Option {
+	return func(o *Options) {
+		rules := tree.MergeRules{}
+		for k, v := range o.MergeRules {
+			rules[k] = v
+		}
+		rules[path] = rule
+		o.MergeRules = rules
+	}
+}
+
+// WithMergeRules adds all given merge rules, see WithMergeRule().
+// Use tree.MergeRulesFromStruct() to get the rules defined by struct tags.
+func WithMergeRules(rules tree.MergeRules) Option {
+	return func(o *Options) {
+		merged := tree.MergeRules{}
+		for k, v := range o.MergeRules {
+			merged[k] = v
+		}
+		for k, v := range rules {
+			merged[k] = v
+		}
+		o.MergeRules = merged
+	}
+}
+
+// WithBeforeSet adds a hook that is called with every element that is about to be written by Set(), SetIn() or Delete().
+// The value is the tree representation of the written object, a tree.Tombstone in case of Delete().
+// The hook can return a different value that is written instead, or an error to reject the write.
+// Rejected writes fail with an ErrRejected error, nothing is written in that case.
+//
+// If a write is split by write routes, the hook is called for every part.
+// So the path may be a parent of the path the hook is interested in, e.g. when the root node is written with Set("", object).
+//
+// Hooks are called from an internal goroutine, one at a time.
+// They must not call any methods of the Config object that write or reload.
+func WithBeforeSet(hook func(path string, value interface{}) (interface{}, error)) Option {
+	return func(o *Options) {
+		o.BeforeSet = append(append([]func(string, interface{}) (interface{}, error){}, o.BeforeSet...), hook)
+	}
+}
+
+// WithBeforeReset adds a hook that is called with every path that is about to be reset by Reset() or ResetIn().
+// The hook can return an error to reject the reset, in this case it fails with an ErrRejected error.
+//
+// Hooks are called from an internal goroutine, one at a time.
+// They must not call any methods of the Config object that write or reload.
+func WithBeforeReset(hook func(path string) error) Option {
+	return func(o *Options) {
+		o.BeforeReset = append(append([]func(string) error{}, o.BeforeReset...), hook)
+	}
+}
+
+// WithAfterWrite adds a hook that is called after every successful write, with the changes of the merged tree.
+// The event contains the old and new elements of every changed path, so this can be used to audit changes.
+//
+// The hook is called before the new tree is published, so c.Get() may still return the old values.
+// Use the New tree of the event instead.
+//
+// Hooks are called from an internal goroutine, one at a time.
+// They must not call any methods of the Config object that write or reload.
+func WithAfterWrite(hook func(e ChangeEvent)) Option {
+	return func(o *Options) {
+		o.AfterWrite = append(append([]func(ChangeEvent){}, o.AfterWrite...), hook)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"sort"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// WriteRoute sends any write to Path, or to anything inside of it, to the layer with the given name.
+// Use Named() to give layers a name, and WithWriteRoute() to add routes.
+type WriteRoute struct {
+	Path  string
+	Layer string
+}
+
+// route is a WriteRoute that is resolved to a layer index.
+type route struct {
+	path  string
+	layer int
+}
+
+// writeOp is a single modification of a layer's tree.
+type writeOp struct {
+	layer       int
+	path        string
+	value       interface{} // Already marshalled value. Unused for resets.
+	reset       bool
+	skipMissing bool // Don't fail if there is nothing to reset.
+}
+
+// apply modifies the given tree.
+func (op writeOp) apply(t tree.Node) error {
+	if !op.reset {
+		return t.Set(op.path, op.value)
+	}
+	if op.skipMissing {
+		if _, ok := t.Lookup(op.path); !ok {
+			return nil
+		}
+	}
+	return t.Remove(op.path)
+}
+
+// resolveRoutes returns the routes with their layer names resolved to indices.
+// The result is sorted by depth, deepest paths first.
+func resolveRoutes(layers []*Layer, writeRoutes []WriteRoute) ([]route, error) {
+	routes := make([]route, 0, len(writeRoutes))
+	for _, wr := range writeRoutes {
+		if tree.PathSplit(wr.Path)[0] != "" {
+			return nil, &tree.ErrPathInvalid{Path: wr.Path, Reason: "First path element has to be empty"}
+		}
+		index := -1
+		for i, layer := range layers {
+			if wr.Layer != "" && layer.Name == wr.Layer {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, &ErrLayerNotFound{wr.Layer}
+		}
+		routes = append(routes, route{wr.Path, index})
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(tree.PathSplit(routes[i].path)) > len(tree.PathSplit(routes[j].path))
+	})
+
+	return routes, nil
+}
+
+// routeLayer returns the index of the layer that writes to the given path go to.
+// Without matching route, this is the layer at index 0.
+func (c *Config) routeLayer(path string) int {
+	for _, r := range c.routes {
+		if tree.PathContains(path, r.path) {
+			return r.layer
+		}
+	}
+	return 0
+}
+
+// nestedRoutes returns all routes that are inside the given path, shallowest first.
+func (c *Config) nestedRoutes(path string) []route {
+	var result []route
+	for i := len(c.routes) - 1; i >= 0; i-- {
+		r := c.routes[i]
+		if r.path != path && tree.PathContains(r.path, path) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// routeSet returns the operations needed to set the already marshalled value at the given path.
+//
+// Parts of the value that belong to nested routes are cut out, and written into their own layers.
+func (c *Config) routeSet(path string, value interface{}) []writeOp {
+	nested := c.nestedRoutes(path)
+	nestedOps := make([]writeOp, 0, len(nested))
+
+	// Cut out the deepest parts first, so that they are not part of any shallower route.
+	for i := len(nested) - 1; i >= 0; i-- {
+		r := nested[i]
+		node, ok := value.(tree.Node)
+		if !ok {
+			break
+		}
+		relPath := r.path[len(path):]
+		v, ok := node.Lookup(relPath)
+		if !ok {
+			continue
+		}
+		node.Remove(relPath)
+		nestedOps = append(nestedOps, writeOp{layer: r.layer, path: r.path, value: v})
+	}
+
+	// Apply the shallowest parts first, so that they don't overwrite deeper ones in the same layer.
+	ops := []writeOp{{layer: c.routeLayer(path), path: path, value: value}}
+	for i := len(nestedOps) - 1; i >= 0; i-- {
+		ops = append(ops, nestedOps[i])
+	}
+
+	return ops
+}
+
+// routeDelete returns the operations needed to delete the given path.
+// A tombstone is written into the routed layer, and everything inside the path that belongs to nested routes is reset.
+func (c *Config) routeDelete(path string) []writeOp {
+	ops := []writeOp{{layer: c.routeLayer(path), path: path, value: tree.Tombstone{}}}
+	for _, r := range c.nestedRoutes(path) {
+		ops = append(ops, writeOp{layer: r.layer, path: r.path, reset: true, skipMissing: true})
+	}
+	return ops
+}
+
+// routeReset returns the operations needed to reset the given path.
+// This includes everything inside the given path that belongs to nested routes.
+func (c *Config) routeReset(path string) []writeOp {
+	ops := []writeOp{{layer: c.routeLayer(path), path: path, reset: true}}
+	for _, r := range c.nestedRoutes(path) {
+		ops = append(ops, writeOp{layer: r.layer, path: r.path, reset: true, skipMissing: true})
+	}
+	return ops
+}
+
+// runBeforeHooks passes all operations to the BeforeSet and BeforeReset hooks.
+// It returns the operations with the values the hooks returned, or an ErrRejected error.
+func (c *Config) runBeforeHooks(ops []writeOp) ([]writeOp, error) {
+	if len(c.options.BeforeSet) == 0 && len(c.options.BeforeReset) == 0 {
+		return ops, nil
+	}
+
+	result := make([]writeOp, 0, len(ops))
+	for _, op := range ops {
+		if op.reset {
+			for _, hook := range c.options.BeforeReset {
+				if err := hook(op.path); err != nil {
+					return nil, &ErrRejected{op.path, err}
+				}
+			}
+		} else {
+			for _, hook := range c.options.BeforeSet {
+				v, err := hook(op.path, op.value)
+				if err != nil {
+					return nil, &ErrRejected{op.path, err}
+				}
+				if op.value, err = tree.Marshal(v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result = append(result, op)
+	}
+
+	return result, nil
+}
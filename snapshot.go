@@ -0,0 +1,98 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"context"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// Snapshot is a read-only view of the configuration at one point in time.
+//
+// All reads of a snapshot see the same generation of the tree, even if the config is changed or reloaded in between.
+// Use Config.Snapshot() to create one.
+type Snapshot struct {
+	generation uint64
+	tree       tree.Node
+	layerTrees []tree.Node
+	layers     []*Layer
+	rules      tree.MergeRules
+	genChan    chan struct{} // Is closed once a newer snapshot is published.
+}
+
+// Snapshot returns a read-only view of the current tree.
+// Taking a snapshot costs nothing, as published trees are never modified, and therefore don't need to be copied.
+func (c *Config) Snapshot() *Snapshot {
+	return c.snapshot()
+}
+
+// snapshot returns the current snapshot.
+func (c *Config) snapshot() *Snapshot {
+	return c.state.Load().(*Snapshot)
+}
+
+// publishSnapshot makes the given trees the current state.
+// This must only be called by the tree update handler, or before it's started.
+func (c *Config) publishSnapshot(generation uint64, t tree.Node, layerTrees []tree.Node) {
+	c.state.Store(&Snapshot{
+		generation: generation,
+		tree:       t,
+		layerTrees: layerTrees,
+		layers:     c.layers,
+		rules:      c.options.MergeRules,
+		genChan:    make(chan struct{}),
+	})
+}
+
+// Generation returns the generation of the current tree.
+// The first tree has the generation 1, every tree that is published afterwards increases it by one.
+func (c *Config) Generation() uint64 {
+	return c.snapshot().generation
+}
+
+// WaitForGeneration waits until the tree has at least the given generation.
+// It returns the context's error if the context is done before, or ErrClosed if the config is closed.
+func (c *Config) WaitForGeneration(ctx context.Context, generation uint64) error {
+	for {
+		s := c.snapshot()
+		if s.generation >= generation {
+			return nil
+		}
+
+		select {
+		case <-s.genChan:
+		case <-c.closedChan:
+			return &ErrClosed{}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Generation returns the generation of the tree the snapshot was taken from, see Config.Generation().
+func (s *Snapshot) Generation() uint64 {
+	return s.generation
+}
+
+// Get will marshal the elements at path into the given object.
+func (s *Snapshot) Get(path string, object interface{}) error {
+	return s.tree.Get(path, object)
+}
+
+// Explain returns the value at the given path, together with the values every single layer contributes to it.
+// See Config.Explain() for details.
+func (s *Snapshot) Explain(path string) (Explanation, error) {
+	return explain(s.layers, s.layerTrees, s.tree, s.rules, path)
+}
+
+// Walk calls f for every element of the tree, except the root node itself.
+// Children are visited in sorted order, after their parent.
+//
+// The values are not copied, they must not be modified.
+func (s *Snapshot) Walk(f func(path string, v interface{})) {
+	walkTree(s.tree, f)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+func TestSnapshot(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage("", nil), UseDummyStorage(".a", 1)}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if g := c.Generation(); g != 1 {
+		t.Errorf("Generation() = %d, want %d", g, 1)
+	}
+
+	s := c.Snapshot()
+
+	if err := c.Set(".a", 2); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Set(".b", 3); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if g := c.Generation(); g != 3 {
+		t.Errorf("Generation() = %d, want %d", g, 3)
+	}
+
+	// The snapshot still contains the old state.
+	if g := s.Generation(); g != 1 {
+		t.Errorf("Snapshot Generation() = %d, want %d", g, 1)
+	}
+	var a int
+	if err := s.Get(".a", &a); err != nil || a != 1 {
+		t.Errorf("Snapshot Get() returned %v, %v, want %v, %v", a, err, 1, nil)
+	}
+	if e, err := s.Explain(".a"); err != nil || e.Winner != 1 {
+		t.Errorf("Snapshot Explain() returned winner %v, %v, want %v, %v", e.Winner, err, 1, nil)
+	}
+	var paths []string
+	s.Walk(func(path string, v interface{}) {
+		paths = append(paths, path)
+	})
+	if len(paths) != 1 || paths[0] != ".a" {
+		t.Errorf("Snapshot Walk() visited %v, want %v", paths, []string{".a"})
+	}
+
+	// A new snapshot contains the new state.
+	if err := c.Snapshot().Get(".a", &a); err != nil || a != 2 {
+		t.Errorf("Snapshot Get() returned %v, %v, want %v, %v", a, err, 2, nil)
+	}
+}
+
+func TestWaitForGeneration(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage("", nil)})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.WaitForGeneration(context.Background(), 1); err != nil {
+		t.Errorf("WaitForGeneration() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForGeneration(ctx, 2); err != context.DeadlineExceeded {
+		t.Errorf("WaitForGeneration() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go c.Reload()
+	if err := c.WaitForGeneration(context.Background(), 2); err != nil {
+		t.Errorf("WaitForGeneration() failed: %v", err)
+	}
+}
+
+func TestSnapshot_Sharing(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage("", nil), UseDummyStorage(".unchanged", map[string]int{"a": 1})}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	s1 := c.Snapshot()
+	if err := c.Set(".changed", 1); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	s2 := c.Snapshot()
+
+	// Unchanged subtrees are shared between generations.
+	a, b := reflect.ValueOf(s1.tree["unchanged"]).Pointer(), reflect.ValueOf(s2.tree["unchanged"]).Pointer()
+	if a == 0 || a != b {
+		t.Errorf("Unchanged subtree isn't shared between generations")
+	}
+}
+
+// sharingStorage is a storage that returns its internal tree, without copying it.
+type sharingStorage struct {
+	tree tree.Node
+}
+
+func (s *sharingStorage) Read() (tree.Node, error) {
+	return s.tree, nil
+}
+
+func (s *sharingStorage) Write(t tree.Node) error {
+	s.tree = t
+	return nil
+}
+
+func (s *sharingStorage) RegisterWatcher(changeChan chan<- struct{}) error {
+	return nil
+}
+
+func TestSnapshot_SharedStorageTree(t *testing.T) {
+	s := &sharingStorage{tree: tree.Node{"s": tree.Node{"a": tree.Number("1")}}}
+	c, err := New([]Storage{s}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	s1 := c.Snapshot()
+	if err := c.Set(".s.a", 2); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	// Writes must not modify the tree the storage returned, as it's part of the old snapshot.
+	var a int
+	if err := s1.Get(".s.a", &a); err != nil || a != 1 {
+		t.Errorf("Old snapshot returned %v, %v, want %v, %v", a, err, 1, nil)
+	}
+	if err := c.Get(".s.a", &a); err != nil || a != 2 {
+		t.Errorf("Get() returned %v, %v, want %v, %v", a, err, 2, nil)
+	}
+}
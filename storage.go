@@ -5,11 +5,91 @@
 
 package config
 
-import "github.com/Dadido3/D3config/tree"
+import (
+	"context"
+	"time"
+
+	"github.com/Dadido3/D3config/tree"
+)
 
 // Storage interface provides arbitrary ways to store/read hierarchical data.
+//
+// Trees returned by Read() are shared with the merged tree, so a storage must not modify them afterwards.
+// They are never modified by the config, so Read() may return the same tree on every call.
 type Storage interface {
 	Read() (tree.Node, error)
 	Write(t tree.Node) error
 	RegisterWatcher(changeChan chan<- struct{}) error
 }
+
+// ContextStorage is implemented by storages that can cancel reads and writes.
+// If a storage implements this, the context variants are used instead of Read() and Write().
+//
+// This is useful for network storages, so that a hanging backend doesn't block SetContext() and similar methods forever.
+type ContextStorage interface {
+	Storage
+	ReadContext(ctx context.Context) (tree.Node, error)
+	WriteContext(ctx context.Context, t tree.Node) error
+}
+
+// readStorage reads the tree from the storage, with the context if it's supported.
+func readStorage(ctx context.Context, s Storage) (tree.Node, error) {
+	if s, ok := s.(ContextStorage); ok {
+		return s.ReadContext(ctx)
+	}
+	return s.Read()
+}
+
+// writeStorage writes the tree into the storage, with the context if it's supported.
+func writeStorage(ctx context.Context, s Storage, t tree.Node) error {
+	if s, ok := s.(ContextStorage); ok {
+		return s.WriteContext(ctx, t)
+	}
+	return s.Write(t)
+}
+
+// ExistenceChecker is implemented by storages that can tell whether their data exists.
+// It's used to check required and optional layers, see Layer.
+type ExistenceChecker interface {
+	Exists() (bool, error)
+}
+
+// WarningReporter is implemented by storages that can have problems that don't prevent them from being read.
+// This includes skipped data and errors while watching for changes.
+// After every read, the warnings are passed to the error handler, see WithErrorHandler().
+type WarningReporter interface {
+	Warnings() []error // Returns the problems that occurred since the last call.
+}
+
+// PollingStorage is implemented by storages that can detect changes by polling, instead of relying on filesystem events.
+//
+// This is useful for network and overlay filesystems that don't support filesystem events.
+type PollingStorage interface {
+	Storage
+	SetPollInterval(interval time.Duration)
+	PollInterval() time.Duration
+}
+
+// Polling enables polling with the given interval for the storage, if it supports it.
+// The storage is returned as it is, so this can be used inline when defining the list of storages:
+//
+//	storages := []config.Storage{
+//		config.Polling(config.UseJSONFile("/mnt/nfs/config.json"), 5*time.Second),
+//	}
+//
+// Layers, like the ones returned by Locked() or Optional(), are unwrapped and the interval is set on their storage.
+// Storages that don't implement the PollingStorage interface are not modified.
+func Polling(storage Storage, interval time.Duration) Storage {
+	s := storage
+	for {
+		l, ok := s.(*Layer)
+		if !ok {
+			break
+		}
+		s = l.Storage
+	}
+	if s, ok := s.(PollingStorage); ok {
+		s.SetPollInterval(interval)
+	}
+	return storage
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"context"
+)
+
+// OverflowPolicy defines what happens if the buffer of a Watch() channel is full.
+type OverflowPolicy int
+
+// Overflow policies that can be used in WatchOptions.
+const (
+	// DropOldest removes the oldest event from the buffer, to make room for the new one.
+	DropOldest OverflowPolicy = iota
+
+	// Coalesce combines all buffered events and the new one into a single event.
+	// The combined event contains the changes between the oldest and the newest tree.
+	// Nothing is sent if the changes cancel each other out.
+	Coalesce
+)
+
+// DefaultWatchBuffer is the buffer size of Watch() channels, if nothing else is defined.
+const DefaultWatchBuffer = 16
+
+// WatchOptions changes the behavior of WatchWithOptions().
+type WatchOptions struct {
+	// Buffer is the number of events the channel can hold.
+	// A value of 0 or less means DefaultWatchBuffer.
+	Buffer int
+
+	// Overflow defines what happens if the buffer is full, see OverflowPolicy.
+	Overflow OverflowPolicy
+
+	// SkipInitial suppresses the initial event that contains all existing elements as added.
+	SkipInitial bool
+}
+
+// Watch returns a channel that receives an event whenever any element inside the given paths changes.
+// Without paths, all changes are sent.
+// Paths can contain wildcards, see tree.PathMatch().
+// Every tree update results in at most one event, even if several paths are affected.
+//
+// The first event contains all existing elements as added, like the initial call of callbacks.
+//
+// The channel has a buffer of DefaultWatchBuffer events, the oldest event is dropped if it's full.
+// Use WatchWithOptions() to change that.
+//
+// The channel is closed once the context is cancelled, or once the Config is closed.
+func (c *Config) Watch(ctx context.Context, paths ...string) <-chan ChangeEvent {
+	return c.WatchWithOptions(ctx, WatchOptions{}, paths...)
+}
+
+// WatchWithOptions is similar to Watch(), but allows to change the buffer size, overflow policy and whether the initial event is sent.
+func (c *Config) WatchWithOptions(ctx context.Context, opts WatchOptions, paths ...string) <-chan ChangeEvent {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultWatchBuffer
+	}
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	eventChan := make(chan ChangeEvent, opts.Buffer)
+
+	// The callback is called one change at a time. It doesn't block, so no change is delayed.
+	// Events are already filtered for the paths.
+	callback := func(c *Config, e ChangeEvent) {
+		for {
+			select {
+			case eventChan <- e:
+				return
+			default:
+			}
+
+			// The buffer is full.
+			switch opts.Overflow {
+			case Coalesce:
+				var oldest *ChangeEvent
+			drain:
+				for {
+					select {
+					case queued := <-eventChan:
+						if oldest == nil {
+							oldest = &queued
+						}
+					default:
+						break drain
+					}
+				}
+				if oldest != nil {
+					e = newChangeEvent(oldest.Old, e.New).filter(paths...)
+					if e.empty() {
+						return
+					}
+				}
+
+			default:
+				select {
+				case <-eventChan:
+				default:
+				}
+			}
+		}
+	}
+
+	id, err := c.register(ctx, eventRegister{paths: paths, eventCallback: callback, skipInitial: opts.SkipInitial})
+	if err != nil {
+		close(eventChan)
+		return eventChan
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Once unregistered, the callback isn't called anymore.
+			resultChan := make(chan (<-chan struct{}))
+			select {
+			case c.listenerChan <- eventUnregister{id, resultChan}:
+				<-<-resultChan
+			case <-c.closedChan:
+			}
+		case <-c.closedChan:
+		}
+		close(eventChan)
+	}()
+
+	return eventChan
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Dadido3/D3config/tree"
+)
+
+// receiveEvent returns the next event of the channel, or fails after some time.
+func receiveEvent(t *testing.T, eventChan <-chan ChangeEvent) ChangeEvent {
+	t.Helper()
+
+	select {
+	case e, ok := <-eventChan:
+		if !ok {
+			t.Fatalf("Channel was closed")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event received")
+	}
+	return ChangeEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage("", tree.Node{"a": 1, "b": 2})}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	eventChan := c.Watch(ctx, ".a", ".b", ".c")
+
+	// The initial event contains everything.
+	if e := receiveEvent(t, eventChan); len(e.Added) != 2 {
+		t.Errorf("Got initial event with %v added, want 2 elements", e.Added)
+	}
+
+	// A single change of several paths results in one event.
+	if err := c.Set("", map[string]int{"a": 3, "c": 4, "d": 5}); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	e := receiveEvent(t, eventChan)
+	if got, want := changePaths(e.Modified), []string{".a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got modified %v, want %v", got, want)
+	}
+	if got, want := changePaths(e.Added), []string{".c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got added %v, want %v", got, want)
+	}
+
+	// The channel is closed once the context is cancelled.
+	cancel()
+	select {
+	case _, ok := <-eventChan:
+		if ok {
+			t.Errorf("Got event after cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Channel wasn't closed")
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	c, err := New([]Storage{UseDummyStorage(".a", 0)}, WithSetWaitsForReload())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dropChan := c.WatchWithOptions(ctx, WatchOptions{Buffer: 1, SkipInitial: true})
+	coalesceChan := c.WatchWithOptions(ctx, WatchOptions{Buffer: 1, Overflow: Coalesce, SkipInitial: true})
+
+	for i := 1; i <= 3; i++ {
+		if err := c.Set(".a", i); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+	if err := c.Set(".b", 1); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond) // Set() doesn't wait for the listeners.
+
+	// Only the newest event is kept.
+	e := receiveEvent(t, dropChan)
+	if got, want := changePaths(e.Added), []string{".b"}; !reflect.DeepEqual(got, want) || len(e.Modified) != 0 {
+		t.Errorf("Got added %v and modified %v, want only %v added", got, changePaths(e.Modified), want)
+	}
+
+	// All events are combined.
+	e = receiveEvent(t, coalesceChan)
+	want := []Change{{Path: ".a", Old: tree.Number("0"), New: tree.Number("3")}}
+	if !reflect.DeepEqual(e.Modified, want) || len(e.Added) != 1 {
+		t.Errorf("Got modified %v and added %v, want %v and 1 added element", e.Modified, e.Added, want)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package tree
+
+import (
+	"reflect"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Builder merges several trees into a new one, without modifying any of them.
+//
+// Subtrees of the merged trees are shared with the result, and are only copied once they have to be modified (copy-on-write).
+// Therefore the merged trees must not be modified as long as the result is in use.
+//
+// Create this by using NewBuilder().
+type Builder struct {
+	root  Node
+	rules MergeRules
+	owned map[uintptr]struct{} // Nodes that were created by the builder, these can be modified in place.
+}
+
+// NewBuilder returns a builder with an empty tree that merges with the given rules, see MergeWithRules().
+func NewBuilder(rules MergeRules) *Builder {
+	b := &Builder{
+		root:  Node{},
+		rules: rules,
+		owned: map[uintptr]struct{}{},
+	}
+	b.owned[nodePointer(b.root)] = struct{}{}
+
+	return b
+}
+
+// Merge merges the new tree into the result, with the rules of the builder.
+// It behaves like Node.MergeWithRules(), but new is not modified.
+func (b *Builder) Merge(new Node) {
+	b.merge(b.root, new, b.rules, "")
+}
+
+// MergeWithRules merges the new tree into the result, with the given rules instead of the ones of the builder.
+func (b *Builder) MergeWithRules(new Node, rules MergeRules) {
+	b.merge(b.root, new, rules, "")
+}
+
+// Remove removes the element and its children at the given path from the result.
+// It behaves like Node.Remove().
+func (b *Builder) Remove(path string) error {
+	pathElements := PathSplit(path)
+
+	if pathElements[0] != "" {
+		return &ErrPathInvalid{path, "First path element has to be empty"}
+	}
+	if len(pathElements) < 2 {
+		// Special case, remove all children.
+		b.root = b.own(Node{})
+		return nil
+	}
+	lastElement := pathElements[len(pathElements)-1]
+	pathElements = pathElements[1 : len(pathElements)-1] // Omit first and last element.
+
+	// Copy all nodes along the path that are not owned yet.
+	node := b.root
+	for _, e := range pathElements {
+		child, ok := node[e]
+		if !ok {
+			return &ErrElementNotFound{path} // Element at path doesn't exist.
+		}
+
+		childNode, ok := child.(Node)
+		if !ok {
+			return &ErrPathInsideValue{path} // Path points inside a value.
+		}
+		childNode = b.own(childNode)
+		node[e] = childNode
+		node = childNode
+	}
+
+	delete(node, lastElement)
+	return nil
+}
+
+// Node returns the resulting tree.
+// The builder must not be used anymore afterwards.
+func (b *Builder) Node() Node {
+	return b.root
+}
+
+// own returns the node itself if it's owned by the builder, otherwise a shallow copy of it that is owned.
+func (b *Builder) own(n Node) Node {
+	if _, ok := b.owned[nodePointer(n)]; ok {
+		return n
+	}
+
+	node := make(Node, len(n))
+	for k, v := range n {
+		node[k] = v
+	}
+	b.owned[nodePointer(node)] = struct{}{}
+
+	return node
+}
+
+// merge is the copy-on-write equivalent of mergeWithRules(), n has to be owned by the builder.
+func (b *Builder) merge(n, new Node, rules MergeRules, prefix string) {
+	for k, vNew := range new {
+		if isTombstone(vNew) {
+			delete(n, k)
+			continue
+		}
+
+		if v, found := n[k]; found {
+			n[k] = b.mergeElements(v, vNew, rules, PathJoin(prefix, k))
+			continue
+		}
+
+		// Element not found in old tree.
+		n[k] = withoutTombstones(vNew)
+	}
+}
+
+// mergeElements is the copy-on-write equivalent of mergeElements().
+// Neither element is modified.
+func (b *Builder) mergeElements(v, vNew interface{}, rules MergeRules, path string) interface{} {
+	rule := rules[path]
+
+	sliceA, aIsSlice := v.([]interface{})
+	sliceB, bIsSlice := vNew.([]interface{})
+	if aIsSlice && bIsSlice {
+		switch rule.Mode {
+		case MergeAppend:
+			return append(append([]interface{}{}, sliceA...), withoutTombstones(sliceB).([]interface{})...)
+		case MergePrepend:
+			return append(append([]interface{}{}, withoutTombstones(sliceB).([]interface{})...), sliceA...)
+		case MergeByKey:
+			return b.mergeSlicesByKey(sliceA, sliceB, rule.Key)
+		}
+	}
+
+	nodeA, aIsNode := v.(Node)
+	nodeB, bIsNode := vNew.(Node)
+	if aIsNode && bIsNode && rule.Mode != MergeReplaceNode {
+		// If both elements are nodes, merge recursively.
+		nodeA = b.own(nodeA)
+		b.merge(nodeA, nodeB, rules, path)
+		return nodeA
+	}
+
+	// If only one or none of the elements is a node, replace the old with the new one.
+	return withoutTombstones(vNew)
+}
+
+// mergeSlicesByKey is the copy-on-write equivalent of mergeSlicesByKey().
+func (b *Builder) mergeSlicesByKey(old, new []interface{}, key string) []interface{} {
+	result := append([]interface{}{}, old...)
+
+	for _, eNew := range new {
+		if isTombstone(eNew) {
+			continue
+		}
+		nodeNew, ok := eNew.(Node)
+		if !ok {
+			result = append(result, eNew)
+			continue
+		}
+		keyNew, ok := nodeNew[key]
+		if !ok {
+			result = append(result, withoutTombstones(nodeNew))
+			continue
+		}
+
+		matched := false
+		for i, e := range result {
+			if node, ok := e.(Node); ok {
+				if k, ok := node[key]; ok && reflect.DeepEqual(k, keyNew) {
+					node = b.own(node)
+					b.merge(node, nodeNew, nil, "")
+					result[i], matched = node, true
+					break
+				}
+			}
+		}
+		if !matched {
+			result = append(result, withoutTombstones(nodeNew))
+		}
+	}
+
+	return result
+}
+
+// withoutTombstones returns the element itself if it doesn't contain any tombstones.
+// Otherwise a copy without tombstones is returned.
+func withoutTombstones(v interface{}) interface{} {
+	if !containsTombstone(v) {
+		return v
+	}
+	return stripTombstones(recursiveCopy(v))
+}
+
+// containsTombstone returns whether the element or any of its children is a tombstone.
+func containsTombstone(v interface{}) bool {
+	switch v := v.(type) {
+	case Tombstone:
+		return true
+
+	case Node:
+		for _, child := range v {
+			if containsTombstone(child) {
+				return true
+			}
+		}
+
+	case []interface{}:
+		for _, child := range v {
+			if containsTombstone(child) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// nodePointer returns the address of the map of the node, this is used to identify nodes.
+func nodePointer(n Node) uintptr {
+	return reflect.ValueOf(n).Pointer()
+}
+
+// sameNode returns whether both nodes are the same instance.
+func sameNode(a, b Node) bool {
+	return a != nil && nodePointer(a) == nodePointer(b)
+}
+
+// Share returns the tree with all subtrees that are equal to the ones in old replaced by the instances of old.
+// If both trees are equal, old itself is returned.
+//
+// This way unchanged subtrees are shared between both trees, and Compare() can skip them.
+// Neither tree is modified, nodes that have to change are copied.
+// Both trees, and therefore the result, must not be modified afterwards.
+func (n Node) Share(old Node) Node {
+	result, _ := share(n, old)
+	return result.(Node) // Something went really wrong if the result is not a Node.
+}
+
+// share returns the new element with all equal subtrees replaced by the ones of the old element, and whether both elements are equal.
+func share(vNew, vOld interface{}) (interface{}, bool) {
+	switch vNew := vNew.(type) {
+	case Node:
+		nodeOld, ok := vOld.(Node)
+		if !ok {
+			return vNew, false
+		}
+		if sameNode(vNew, nodeOld) {
+			return vNew, true
+		}
+
+		equal := len(vNew) == len(nodeOld)
+		var result Node // Copy of vNew with shared children, only created if needed.
+		for k, child := range vNew {
+			childOld, found := nodeOld[k]
+			if !found {
+				equal = false
+				continue
+			}
+			shared, childEqual := share(child, childOld)
+			equal = equal && childEqual
+			if !sameElement(child, shared) {
+				if result == nil {
+					result = make(Node, len(vNew))
+					for k, v := range vNew {
+						result[k] = v
+					}
+				}
+				result[k] = shared
+			}
+		}
+
+		if equal {
+			return nodeOld, true
+		}
+		if result != nil {
+			return result, false
+		}
+		return vNew, false
+
+	case []interface{}:
+		sliceOld, ok := vOld.([]interface{})
+		if !ok || len(vNew) != len(sliceOld) {
+			return vNew, false
+		}
+		for i := range vNew {
+			if _, equal := share(vNew[i], sliceOld[i]); !equal {
+				return vNew, false
+			}
+		}
+		return sliceOld, true
+	}
+
+	if _, ok := vOld.(Node); ok {
+		return vNew, false
+	}
+	if _, ok := vOld.([]interface{}); ok {
+		return vNew, false
+	}
+	return vNew, cmp.Equal(vNew, vOld)
+}
+
+// sameElement returns whether both elements are the same instance.
+// Values are always treated as the same, as there is nothing to share.
+func sameElement(a, b interface{}) bool {
+	switch a := a.(type) {
+	case Node:
+		nodeB, ok := b.(Node)
+		return ok && sameNode(a, nodeB)
+	case []interface{}:
+		sliceB, ok := b.([]interface{})
+		return ok && len(a) == len(sliceB) && (len(a) == 0 || &a[0] == &sliceB[0])
+	}
+	return true // Values don't need to be shared.
+}
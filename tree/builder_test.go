@@ -0,0 +1,128 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package tree
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	newA := func() Node {
+		return Node{
+			"plugins": []interface{}{"a", "b"},
+			"servers": []interface{}{
+				Node{"name": "x", "port": Number("1")},
+				Node{"name": "y", "port": Number("2")},
+			},
+			"limits": Node{"cpu": Number("1"), "memory": Number("2")},
+			"nested": Node{"a": Node{"b": Number("1"), "c": Number("2")}},
+		}
+	}
+	newB := func() Node {
+		return Node{
+			"plugins": []interface{}{"c"},
+			"servers": []interface{}{
+				Node{"name": "y", "port": Number("3"), "tls": true},
+				Node{"name": "z"},
+			},
+			"limits": Node{"cpu": Number("4")},
+			"nested": Node{"a": Node{"b": Tombstone{}}},
+			"added":  Node{"a": Node{"b": Tombstone{}, "c": true}},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		rules MergeRules
+	}{
+		{"Default", nil},
+		{"Append", MergeRules{".plugins": {Mode: MergeAppend}}},
+		{"Prepend", MergeRules{".plugins": {Mode: MergePrepend}}},
+		{"ByKey", MergeRules{".servers": {Mode: MergeByKey, Key: "name"}}},
+		{"ReplaceNode", MergeRules{".limits": {Mode: MergeReplaceNode}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := Node{}
+			want.MergeWithRules(newA(), tt.rules)
+			want.MergeWithRules(newB(), tt.rules)
+
+			a, b := newA(), newB()
+			builder := NewBuilder(tt.rules)
+			builder.Merge(a)
+			builder.Merge(b)
+			got := builder.Node()
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Node() = %v, want %v", got, want)
+			}
+			if !reflect.DeepEqual(a, newA()) || !reflect.DeepEqual(b, newB()) {
+				t.Errorf("Merged trees got modified: %v, %v", a, b)
+			}
+		})
+	}
+
+	t.Run("Remove", func(t *testing.T) {
+		a := newA()
+		builder := NewBuilder(nil)
+		builder.Merge(a)
+		if err := builder.Remove(".nested.a.b"); err != nil {
+			t.Fatalf("Remove() failed: %v", err)
+		}
+		got := builder.Node()
+
+		want := Node{"a": Node{"c": Number("2")}}
+		if !reflect.DeepEqual(got["nested"], want) {
+			t.Errorf("Node() contains %v, want %v", got["nested"], want)
+		}
+		if !reflect.DeepEqual(a, newA()) {
+			t.Errorf("Merged tree got modified: %v", a)
+		}
+		if !sameNode(got["limits"].(Node), a["limits"].(Node)) {
+			t.Errorf("Unmodified subtree isn't shared")
+		}
+	})
+}
+
+func TestNode_Share(t *testing.T) {
+	old := Node{
+		"a": Node{"b": Number("1"), "c": []interface{}{Node{"d": true}}},
+		"e": Node{"f": Node{"g": "h"}, "i": Number("2")},
+	}
+	new := Node{
+		"a": Node{"b": Number("1"), "c": []interface{}{Node{"d": true}}},
+		"e": Node{"f": Node{"g": "h"}, "i": Number("3")},
+	}
+	newCopy := new.Copy()
+
+	result := new.Share(old)
+
+	if !reflect.DeepEqual(result, newCopy) {
+		t.Errorf("Share() = %v, want %v", result, newCopy)
+	}
+	if !reflect.DeepEqual(new, newCopy) {
+		t.Errorf("Share() modified the tree: %v", new)
+	}
+	if !sameNode(result["a"].(Node), old["a"].(Node)) {
+		t.Errorf("Equal subtree .a isn't shared")
+	}
+	if !sameNode(result["e"].(Node)["f"].(Node), old["e"].(Node)["f"].(Node)) {
+		t.Errorf("Equal subtree .e.f isn't shared")
+	}
+	if sameNode(result["e"].(Node), old["e"].(Node)) {
+		t.Errorf("Modified subtree .e is shared")
+	}
+
+	if result := old.Copy().Share(old); !sameNode(result, old) {
+		t.Errorf("Share() of equal trees doesn't return the old tree")
+	}
+
+	modified, added, removed := old.Compare(result)
+	if !reflect.DeepEqual(modified, []string{".e.i"}) || len(added) != 0 || len(removed) != 0 {
+		t.Errorf("Compare() = %v, %v, %v, want %v, [], []", modified, added, removed, []string{".e.i"})
+	}
+}
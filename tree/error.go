@@ -73,3 +73,12 @@ type ErrCannotModify struct {
 func (e *ErrCannotModify) Error() string {
 	return fmt.Sprintf("trying to write into non pointer or nil value %v of type %v", e.Value, e.Type)
 }
+
+// ErrInvalidMergeRule is returned if a merge rule can't be parsed.
+type ErrInvalidMergeRule struct {
+	Rule string
+}
+
+func (e *ErrInvalidMergeRule) Error() string {
+	return fmt.Sprintf("merge rule %q is invalid", e.Rule)
+}
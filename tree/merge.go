@@ -0,0 +1,208 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package tree
+
+import (
+	"reflect"
+	"strings"
+)
+
+// MergeMode defines how an element of a new tree is merged with the element of an old tree.
+type MergeMode int
+
+// Merge modes that can be used in a MergeRule.
+const (
+	MergeReplace     MergeMode = iota // Nodes are merged recursively, anything else is replaced. This is the default.
+	MergeAppend                       // Slices of the new tree are appended to the ones of the old tree.
+	MergePrepend                      // Slices of the new tree are put in front of the ones of the old tree.
+	MergeByKey                        // Slices of nodes are merged element by element, elements are matched by the value at MergeRule.Key.
+	MergeReplaceNode                  // Nodes of the new tree replace the ones of the old tree entirely, instead of being merged.
+)
+
+// MergeRule defines how the element at some path is merged.
+//
+// Slice modes only apply if both elements are slices, otherwise the default behavior is used.
+type MergeRule struct {
+	Mode MergeMode
+	Key  string // Key that identifies elements of slices, only used with MergeByKey.
+}
+
+// MergeRules maps paths to the rule that is used to merge the element at that path.
+type MergeRules map[string]MergeRule
+
+// ParseMergeRule parses the textual representation of a merge rule, as used in struct tags.
+//
+// Valid values are "replace", "append", "prepend", "replace-node" and "key:name", where name is the key that identifies slice elements.
+func ParseMergeRule(s string) (MergeRule, error) {
+	switch s {
+	case "replace":
+		return MergeRule{Mode: MergeReplace}, nil
+	case "append":
+		return MergeRule{Mode: MergeAppend}, nil
+	case "prepend":
+		return MergeRule{Mode: MergePrepend}, nil
+	case "replace-node":
+		return MergeRule{Mode: MergeReplaceNode}, nil
+	}
+
+	if strings.HasPrefix(s, "key:") {
+		key := strings.TrimPrefix(s, "key:")
+		if key != "" && !strings.Contains(key, PathSeparator) {
+			return MergeRule{Mode: MergeByKey, Key: key}, nil
+		}
+	}
+
+	return MergeRule{}, &ErrInvalidMergeRule{s}
+}
+
+// MergeRulesFromStruct returns the merge rules that are defined by the struct tags of the given object.
+// The object itself is not read, only its type is relevant.
+//
+// Rules are defined with the merge option of the conf tag:
+//
+//	type Config struct {
+//		Plugins []string `conf:"plugins,merge=append"`
+//		Servers []Server `conf:"servers,merge=key:name"`
+//		Limits  Limits   `conf:"limits,merge=replace-node"`
+//	}
+func MergeRulesFromStruct(obj interface{}) (MergeRules, error) {
+	rules := MergeRules{}
+	if err := mergeRulesFromType(reflect.TypeOf(obj), "", rules, map[reflect.Type]bool{}); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// mergeRulesFromType recursively collects the merge rules of all fields of the given type.
+// visiting contains all types of the current branch, to prevent endless recursion.
+func mergeRulesFromType(t reflect.Type, prefix string, rules MergeRules, visiting map[reflect.Type]bool) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		name, options := getTags(ft)
+		if ft.PkgPath != "" || options["omit"] == true { // Ignore unexported fields, or fields with "omit" set.
+			continue
+		}
+		path := PathJoin(prefix, name)
+
+		if s, ok := options["merge"].(string); ok {
+			rule, err := ParseMergeRule(s)
+			if err != nil {
+				return err
+			}
+			rules[path] = rule
+		}
+
+		if err := mergeRulesFromType(ft.Type, path, rules, visiting); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MergeWithRules merges this tree with the new one.
+//
+// It behaves like Merge(), but the elements at the paths defined in rules are merged according to their rule.
+func (n Node) MergeWithRules(new Node, rules MergeRules) {
+	n.mergeWithRules(new, rules, "")
+}
+
+func (n Node) mergeWithRules(new Node, rules MergeRules, prefix string) {
+	for k, vNew := range new {
+		if isTombstone(vNew) {
+			delete(n, k)
+			continue
+		}
+
+		v, found := n[k]
+
+		if found {
+			n[k] = mergeElements(v, vNew, rules, PathJoin(prefix, k))
+			continue
+		}
+
+		// Element not found in old tree.
+		n[k] = stripTombstones(vNew)
+	}
+}
+
+// mergeElements returns the result of merging the new into the old element at the given path.
+// The old element may be modified.
+func mergeElements(v, vNew interface{}, rules MergeRules, path string) interface{} {
+	rule := rules[path]
+
+	sliceA, aIsSlice := v.([]interface{})
+	sliceB, bIsSlice := vNew.([]interface{})
+	if aIsSlice && bIsSlice {
+		switch rule.Mode {
+		case MergeAppend:
+			return append(append([]interface{}{}, sliceA...), stripTombstones(sliceB).([]interface{})...)
+		case MergePrepend:
+			return append(append([]interface{}{}, stripTombstones(sliceB).([]interface{})...), sliceA...)
+		case MergeByKey:
+			return mergeSlicesByKey(sliceA, sliceB, rule.Key)
+		}
+	}
+
+	nodeA, aIsNode := v.(Node)
+	nodeB, bIsNode := vNew.(Node)
+	if aIsNode && bIsNode && rule.Mode != MergeReplaceNode {
+		// If both elements are nodes, merge recursively.
+		nodeA.mergeWithRules(nodeB, rules, path)
+		return nodeA
+	}
+
+	// If only one or none of the elements is a node, replace the old with the new one.
+	return stripTombstones(vNew)
+}
+
+// mergeSlicesByKey merges the elements of both slices that have the same value at the given key.
+// Elements of the new slice without a match are appended.
+func mergeSlicesByKey(old, new []interface{}, key string) []interface{} {
+	result := append([]interface{}{}, old...)
+
+	for _, eNew := range new {
+		if isTombstone(eNew) {
+			continue
+		}
+		nodeNew, ok := eNew.(Node)
+		if !ok {
+			result = append(result, eNew)
+			continue
+		}
+		keyNew, ok := nodeNew[key]
+		if !ok {
+			result = append(result, stripTombstones(nodeNew))
+			continue
+		}
+
+		matched := false
+		for i, e := range result {
+			if node, ok := e.(Node); ok {
+				if k, ok := node[key]; ok && reflect.DeepEqual(k, keyNew) {
+					node.Merge(nodeNew)
+					result[i], matched = node, true
+					break
+				}
+			}
+		}
+		if !matched {
+			result = append(result, stripTombstones(nodeNew))
+		}
+	}
+
+	return result
+}
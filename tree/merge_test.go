@@ -0,0 +1,116 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package tree
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNode_MergeWithRules(t *testing.T) {
+	newOld := func() Node {
+		return Node{
+			"plugins": []interface{}{"a", "b"},
+			"servers": []interface{}{
+				Node{"name": "x", "port": Number("1")},
+				Node{"name": "y", "port": Number("2")},
+			},
+			"limits": Node{"cpu": Number("1"), "memory": Number("2")},
+		}
+	}
+	newNew := func() Node {
+		return Node{
+			"plugins": []interface{}{"c"},
+			"servers": []interface{}{
+				Node{"name": "y", "port": Number("3"), "tls": true},
+				Node{"name": "z"},
+			},
+			"limits": Node{"cpu": Number("4")},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		rules    MergeRules
+		wantThis Node
+	}{
+		{"Default", nil, Node{
+			"plugins": []interface{}{"c"},
+			"servers": []interface{}{Node{"name": "y", "port": Number("3"), "tls": true}, Node{"name": "z"}},
+			"limits":  Node{"cpu": Number("4"), "memory": Number("2")},
+		}},
+		{"Append", MergeRules{".plugins": {Mode: MergeAppend}}, Node{
+			"plugins": []interface{}{"a", "b", "c"},
+			"servers": []interface{}{Node{"name": "y", "port": Number("3"), "tls": true}, Node{"name": "z"}},
+			"limits":  Node{"cpu": Number("4"), "memory": Number("2")},
+		}},
+		{"Prepend", MergeRules{".plugins": {Mode: MergePrepend}}, Node{
+			"plugins": []interface{}{"c", "a", "b"},
+			"servers": []interface{}{Node{"name": "y", "port": Number("3"), "tls": true}, Node{"name": "z"}},
+			"limits":  Node{"cpu": Number("4"), "memory": Number("2")},
+		}},
+		{"ByKey", MergeRules{".servers": {Mode: MergeByKey, Key: "name"}}, Node{
+			"plugins": []interface{}{"c"},
+			"servers": []interface{}{
+				Node{"name": "x", "port": Number("1")},
+				Node{"name": "y", "port": Number("3"), "tls": true},
+				Node{"name": "z"},
+			},
+			"limits": Node{"cpu": Number("4"), "memory": Number("2")},
+		}},
+		{"ReplaceNode", MergeRules{".limits": {Mode: MergeReplaceNode}}, Node{
+			"plugins": []interface{}{"c"},
+			"servers": []interface{}{Node{"name": "y", "port": Number("3"), "tls": true}, Node{"name": "z"}},
+			"limits":  Node{"cpu": Number("4")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newOld()
+			n.MergeWithRules(newNew(), tt.rules)
+			if !reflect.DeepEqual(n, tt.wantThis) {
+				t.Errorf("this = %v, want %v", n, tt.wantThis)
+			}
+			if err := n.Check(); err != nil {
+				t.Errorf("Illegal element in tree: %v", err)
+			}
+		})
+	}
+}
+
+func TestMergeRulesFromStruct(t *testing.T) {
+	type Server struct {
+		Name  string   `conf:"name"`
+		Hosts []string `conf:"hosts,merge=prepend"`
+	}
+	type Config struct {
+		Plugins []string          `conf:"plugins,merge=append"`
+		Servers []Server          `conf:"servers,merge=key:name"`
+		Main    *Server           `conf:"main"`
+		Limits  map[string]string `conf:"limits,merge=replace-node"`
+		Next    *Config           `conf:"next"`
+	}
+
+	got, err := MergeRulesFromStruct(Config{})
+	if err != nil {
+		t.Fatalf("MergeRulesFromStruct() failed: %v", err)
+	}
+	want := MergeRules{
+		".plugins":    {Mode: MergeAppend},
+		".servers":    {Mode: MergeByKey, Key: "name"},
+		".main.hosts": {Mode: MergePrepend},
+		".limits":     {Mode: MergeReplaceNode},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeRulesFromStruct() = %v, want %v", got, want)
+	}
+
+	if _, err := MergeRulesFromStruct(struct {
+		A []string `conf:"a,merge=foo"`
+	}{}); err == nil {
+		t.Errorf("MergeRulesFromStruct() didn't fail for an invalid rule")
+	}
+}
@@ -8,6 +8,7 @@ package tree
 import (
 	"fmt"
 	"reflect"
+	"sort"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -107,7 +108,7 @@ func (n Node) Get(path string, obj interface{}) error {
 			return &ErrPathInsideValue{path} // Path points inside a value.
 		}
 		inter, ok = node[e]
-		if !ok {
+		if !ok || isTombstone(inter) {
 			return &ErrElementNotFound{path} // Element at path doesn't exist.
 		}
 	}
@@ -115,6 +116,88 @@ func (n Node) Get(path string, obj interface{}) error {
 	return unmarshal(inter, reflect.ValueOf(obj))
 }
 
+// Lookup returns the element at the given path, and whether it exists.
+//
+// The element is not copied, so it must not be modified.
+// Use Get() to get a copy.
+//
+// Other than Get(), this returns tombstones as they are.
+func (n Node) Lookup(path string) (interface{}, bool) {
+	elements := PathSplit(path)
+
+	if elements[0] != "" {
+		return nil, false
+	}
+	elements = elements[1:] // Omit first element.
+
+	inter := interface{}(n)
+	for _, e := range elements {
+		node, ok := inter.(Node)
+		if !ok {
+			return nil, false // Path points inside a value.
+		}
+		inter, ok = node[e]
+		if !ok {
+			return nil, false // Element at path doesn't exist.
+		}
+	}
+
+	return inter, true
+}
+
+// Matches returns the sorted paths of all elements that are matched by the given pattern.
+// See PathMatch() for the pattern syntax.
+func (n Node) Matches(pattern string) []string {
+	elements := PathSplit(pattern)
+	if elements[0] != "" {
+		return nil
+	}
+
+	found := map[string]struct{}{}
+	var recursive func(v interface{}, path string, pat []string)
+	recursive = func(v interface{}, path string, pat []string) {
+		if len(pat) == 0 {
+			found[path] = struct{}{}
+			return
+		}
+
+		node, ok := v.(Node)
+		if pat[0] == PathWildcardAnyDepth {
+			recursive(v, path, pat[1:]) // Match no element.
+			if ok {
+				for k, child := range node {
+					if !isTombstone(child) {
+						recursive(child, PathJoin(path, k), pat)
+					}
+				}
+			}
+			return
+		}
+		if !ok {
+			return
+		}
+
+		if pat[0] == PathWildcard {
+			for k, child := range node {
+				if !isTombstone(child) {
+					recursive(child, PathJoin(path, k), pat[1:])
+				}
+			}
+		} else if child, ok := node[pat[0]]; ok && !isTombstone(child) {
+			recursive(child, PathJoin(path, pat[0]), pat[1:])
+		}
+	}
+	recursive(n, "", elements[1:])
+
+	result := make([]string, 0, len(found))
+	for path := range found {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
 // Remove removes the element and its children at the given path from the tree.
 func (n Node) Remove(path string) error {
 	pathElements := PathSplit(path)
@@ -188,14 +271,25 @@ func (n Node) GetFloat64(path string, fallback float64) (result float64) {
 // Compare compares the current tree with the one in new and returns a list of paths for elements that were modified, added or removed.
 //
 // A change of the content/sub-content of a slice is returned as change of the slice itself.
+// Tombstones are treated as absent elements.
+//
+// Subtrees that are shared between both trees are skipped, see Share().
 func (n Node) Compare(new Node) (modified, added, removed []string) {
 	return n.compare(new, ".")
 }
 
 func (n Node) compare(new Node, prefix string) (modified, added, removed []string) {
+	if sameNode(n, new) {
+		return nil, nil, nil // Shared subtrees are equal.
+	}
+
 	// Look for modified or removed elements.
 	for k, v := range n {
+		if isTombstone(v) {
+			continue
+		}
 		vNew, foundNew := new[k]
+		foundNew = foundNew && !isTombstone(vNew)
 
 		if foundNew {
 			nodeA, aIsNode := v.(Node)
@@ -231,9 +325,12 @@ func (n Node) compare(new Node, prefix string) (modified, added, removed []strin
 
 	// Look for added elements.
 	for k, vNew := range new {
-		_, found := n[k]
+		if isTombstone(vNew) {
+			continue
+		}
+		v, found := n[k]
 
-		if !found {
+		if !found || isTombstone(v) {
 			added = append(added, prefix+k)
 			if nodeB, ok := vNew.(Node); ok {
 				_, add, _ := Node{}.compare(nodeB, prefix+k+PathSeparator)
@@ -254,26 +351,11 @@ func (n Node) compare(new Node, prefix string) (modified, added, removed []strin
 // - If there is some element in the new, but not in the old tree, the new one is written.
 //
 // Slices will not be merged, but new ones will overwrite old ones.
+// Use MergeWithRules() to change that for specific paths.
+//
+// Tombstones in the new tree remove the element at their path, see Tombstone.
 func (n Node) Merge(new Node) {
-	for k, vNew := range new {
-		v, found := n[k]
-
-		if found {
-			nodeA, aIsNode := v.(Node)
-			nodeB, bIsNode := vNew.(Node)
-			if aIsNode && bIsNode {
-				// If both elements are nodes, merge recursively.
-				nodeA.Merge(nodeB)
-			} else {
-				// If only one or none of the elements is a node, replace the old with the new one.
-				n[k] = vNew
-			}
-			continue
-		}
-
-		// Element not found in old tree.
-		n[k] = vNew
-	}
+	n.mergeWithRules(new, nil, "")
 }
 
 // Copy returns a copy of itself.
@@ -290,7 +372,7 @@ func recursiveCopy(v interface{}) interface{} {
 		}
 		return node
 
-	case bool, string, Number:
+	case bool, string, Number, Tombstone:
 		return v
 
 	case nil:
@@ -324,7 +406,7 @@ func (n Node) Check() error {
 			}
 			return nil
 
-		case bool, string, Number:
+		case bool, string, Number, Tombstone:
 			return nil
 
 		case nil:
@@ -394,3 +394,72 @@ func TestNode_Remove(t *testing.T) {
 		}
 	}
 }
+
+func TestNode_Lookup(t *testing.T) {
+	n := Node{
+		"someString": "someString",
+		"subnode": Node{
+			"b": Node{
+				"someFloat": Number("123.456"),
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		want      interface{}
+		wantFound bool
+	}{
+		{"A", "", n, true},
+		{"B", ".someString", "someString", true},
+		{"C", ".subnode.b", Node{"someFloat": Number("123.456")}, true},
+		{"D", ".subnode.b.someFloat", Number("123.456"), true},
+		{"E", ".subnode.x", nil, false},
+		{"F", ".someString.foo", nil, false},
+		{"G", "someString", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotFound := n.Lookup(tt.path)
+			if gotFound != tt.wantFound {
+				t.Errorf("Node.Lookup() gotFound = %v, want %v", gotFound, tt.wantFound)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Node.Lookup() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNode_Matches(t *testing.T) {
+	n := Node{
+		"services": Node{
+			"web": Node{"port": Number("80"), "host": "localhost"},
+			"db":  Node{"port": Number("5432")},
+			"old": Tombstone{},
+		},
+		"port": Number("1"),
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{"A", ".services.*.port", []string{".services.db.port", ".services.web.port"}},
+		{"B", ".**.port", []string{".port", ".services.db.port", ".services.web.port"}},
+		{"C", ".services.*", []string{".services.db", ".services.web"}},
+		{"D", ".services.web", []string{".services.web"}},
+		{"E", ".services.x", []string{}},
+		{"F", ".**.**.host", []string{".services.web.host"}},
+		{"G", "", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := n.Matches(tt.pattern); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Node.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -12,6 +12,12 @@ import (
 // PathSeparator delimits single path elements.
 const PathSeparator = "."
 
+// Wildcards that can be used as elements of path patterns.
+const (
+	PathWildcard         = "*"  // Matches exactly one path element.
+	PathWildcardAnyDepth = "**" // Matches any number of path elements, including none.
+)
+
 // PathJoin creates a new path from several path strings.
 func PathJoin(elem ...string) string {
 	return strings.Join(elem, PathSeparator)
@@ -38,3 +44,46 @@ func PathContains(path, subPath string) bool {
 
 	return true
 }
+
+// PathMatch returns whether the path is matched by the given pattern.
+//
+// Patterns are paths that can contain wildcard elements.
+// A "*" element matches exactly one element, a "**" element matches any number of elements, including none.
+// For example ".services.*.port" matches ".services.web.port", and ".services.**" matches ".services" and everything inside of it.
+func PathMatch(path, pattern string) bool {
+	return matchElements(PathSplit(path), PathSplit(pattern))
+}
+
+// PathContainsMatch returns whether the path is at or inside any element matched by the given pattern.
+// It's the pattern equivalent of PathContains(), see PathMatch() for the pattern syntax.
+func PathContainsMatch(path, pattern string) bool {
+	p, pat := PathSplit(path), PathSplit(pattern)
+
+	for i := len(p); i > 0; i-- {
+		if matchElements(p[:i], pat) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchElements returns whether the path elements are matched by the pattern elements.
+func matchElements(p, pat []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == PathWildcardAnyDepth {
+			for i := 0; i <= len(p); i++ {
+				if matchElements(p[i:], pat[1:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(p) == 0 || pat[0] != PathWildcard && pat[0] != p[0] {
+			return false
+		}
+		p, pat = p[1:], pat[1:]
+	}
+
+	return len(p) == 0
+}
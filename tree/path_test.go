@@ -34,3 +34,39 @@ func TestPathSplit(t *testing.T) {
 		})
 	}
 }
+
+func TestPathMatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		pattern      string
+		want         bool
+		wantContains bool
+	}{
+		{"A", ".a.b", ".a.b", true, true},
+		{"B", ".a.b", ".a.*", true, true},
+		{"C", ".a.b.c", ".a.*", false, true},
+		{"D", ".a", ".a.*", false, false},
+		{"E", ".services.web.port", ".services.*.port", true, true},
+		{"F", ".services.web.host", ".services.*.port", false, false},
+		{"G", ".services.web.port.x", ".services.*.port", false, true},
+		{"H", ".a", ".a.**", true, true},
+		{"I", ".a.b.c", ".a.**", true, true},
+		{"J", ".a.b.c.port", ".**.port", true, true},
+		{"K", ".port", ".**.port", true, true},
+		{"L", ".b.c", ".a.**", false, false},
+		{"M", "", "", true, true},
+		{"N", ".a", "", false, true},
+		{"O", ".a.x.b.y.c", ".a.**.b.*.c", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PathMatch(tt.path, tt.pattern); got != tt.want {
+				t.Errorf("PathMatch() = %v, want %v", got, tt.want)
+			}
+			if got := PathContainsMatch(tt.path, tt.pattern); got != tt.wantContains {
+				t.Errorf("PathContainsMatch() = %v, want %v", got, tt.wantContains)
+			}
+		})
+	}
+}
@@ -33,15 +33,25 @@ func getTags(f reflect.StructField) (name string, options map[string]interface{}
 	name = split[0]
 
 	for _, v := range split[1:] {
-		switch v {
-		case "omit":
+		switch {
+		case v == "omit":
 			options[v] = true
+		case strings.HasPrefix(v, "merge="):
+			options["merge"] = strings.TrimPrefix(v, "merge=")
 		}
 	}
 
 	return
 }
 
+// Marshal converts any value into a tree element.
+// The result is either a Node, a slice, or any other value a tree can contain.
+//
+// Everything is copied, the result will not contain references to the original value.
+func Marshal(obj interface{}) (interface{}, error) {
+	return marshal(reflect.ValueOf(obj))
+}
+
 // marshal recursively converts any values to a valid tree.
 // Everything is copied, it will not contain references to the original values.
 func marshal(v reflect.Value) (interface{}, error) {
@@ -51,6 +61,8 @@ func marshal(v reflect.Value) (interface{}, error) {
 	}
 
 	switch i := v.Interface().(type) {
+	case Tombstone:
+		return i, nil
 	case Number, json.Number:
 		return NumberCreate(i)
 	case encoding.TextMarshaler:
@@ -104,6 +116,9 @@ func marshal(v reflect.Value) (interface{}, error) {
 				return nil, err
 			}
 		}
+		if len(node) == 1 && node[TombstoneKey] == true {
+			return Tombstone{}, nil // Reserved representation of a tombstone.
+		}
 		return node, nil
 
 	case reflect.Array, reflect.Slice:
@@ -211,7 +226,7 @@ func unmarshal(tree interface{}, v reflect.Value) error {
 				ft, fv := t.Field(i), rStruct.Field(i)
 				name, options := getTags(ft)
 				if ft.PkgPath == "" && !(options["omit"] == true) { // Ignore unexported fields, or fields with "omit" set.
-					if subTree, ok := node[name]; ok {
+					if subTree, ok := node[name]; ok && !isTombstone(subTree) {
 						err := unmarshal(subTree, fv)
 						if err != nil {
 							return err
@@ -230,6 +245,9 @@ func unmarshal(tree interface{}, v reflect.Value) error {
 			}
 			rMap := reflect.MakeMap(t)
 			for k, tv := range node {
+				if isTombstone(tv) {
+					continue
+				}
 				rv := reflect.New(t.Elem()).Elem()
 				err := unmarshal(tv, rv)
 				if err != nil {
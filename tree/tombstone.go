@@ -0,0 +1,90 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package tree
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// TombstoneKey is the key of the reserved node that represents a Tombstone: {"$delete": true}.
+const TombstoneKey = "$delete"
+
+// tombstoneTag is the YAML tag that represents a Tombstone.
+const tombstoneTag = "!delete"
+
+// Tombstone marks an element as deleted.
+//
+// When a tree is merged into another one, a tombstone removes the element at its path, instead of being written.
+// This way a higher priority storage can remove elements that are defined in lower priority storages.
+// Trees that are the result of a merge don't contain any tombstones.
+//
+// Tombstones are treated as absent elements by Get() and Compare().
+//
+// In JSON, a tombstone is written as {"$delete": true}.
+// In YAML, the !delete tag is used, but {"$delete": true} works too.
+type Tombstone struct{}
+
+// MarshalJSON returns the JSON representation of a tombstone.
+func (Tombstone) MarshalJSON() ([]byte, error) {
+	return []byte(`{"` + TombstoneKey + `":true}`), nil
+}
+
+// MarshalYAML returns the YAML representation of a tombstone.
+func (Tombstone) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tombstoneTag, Style: yaml.TaggedStyle}, nil
+}
+
+// isTombstone returns whether the element is a Tombstone.
+func isTombstone(v interface{}) bool {
+	_, ok := v.(Tombstone)
+	return ok
+}
+
+// stripTombstones removes all tombstones from the given element and its children.
+// Nodes and slices are modified in place, the result has to be used for slices.
+func stripTombstones(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Node:
+		for k, child := range v {
+			if isTombstone(child) {
+				delete(v, k)
+				continue
+			}
+			v[k] = stripTombstones(child)
+		}
+		return v
+
+	case []interface{}:
+		result := v[:0]
+		for _, child := range v {
+			if !isTombstone(child) {
+				result = append(result, stripTombstones(child))
+			}
+		}
+		return result
+	}
+
+	return v
+}
+
+// replaceTombstoneTags replaces all YAML nodes that have the !delete tag with their {"$delete": true} representation.
+func replaceTombstoneTags(n *yaml.Node) {
+	if n.Tag == tombstoneTag {
+		*n = yaml.Node{
+			Kind: yaml.MappingNode,
+			Tag:  "!!map",
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: TombstoneKey},
+				{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"},
+			},
+		}
+		return
+	}
+
+	for _, child := range n.Content {
+		replaceTombstoneTags(child)
+	}
+}
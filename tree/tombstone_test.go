@@ -0,0 +1,119 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package tree
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTombstone_Merge(t *testing.T) {
+	n := Node{
+		"a": Number("1"),
+		"b": Node{"c": Number("2"), "d": Number("3")},
+	}
+	n.Merge(Node{
+		"a": Tombstone{},
+		"b": Node{"c": Tombstone{}},
+		"e": Node{"f": Tombstone{}, "g": true},
+		"h": Tombstone{},
+	})
+
+	want := Node{
+		"b": Node{"d": Number("3")},
+		"e": Node{"g": true},
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("this = %v, want %v", n, want)
+	}
+}
+
+func TestTombstone_Compare(t *testing.T) {
+	old := Node{"a": Number("1"), "b": Tombstone{}}
+	new := Node{"a": Tombstone{}, "b": Tombstone{}, "c": Tombstone{}}
+
+	modified, added, removed := old.Compare(new)
+	if len(modified) != 0 || len(added) != 0 || !reflect.DeepEqual(removed, []string{".a"}) {
+		t.Errorf("Compare() = %v, %v, %v, want [], [], [.a]", modified, added, removed)
+	}
+}
+
+func TestTombstone_Marshalling(t *testing.T) {
+	n := Node{"a": Tombstone{}, "b": Node{"c": Tombstone{}}}
+
+	jsonBytes, err := json.Marshal(n)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	jsonNode := Node{}
+	if err := json.Unmarshal(jsonBytes, &jsonNode); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(jsonNode, n) {
+		t.Errorf("Got %v from JSON %s, want %v", jsonNode, jsonBytes, n)
+	}
+
+	yamlBytes, err := yaml.Marshal(n)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() failed: %v", err)
+	}
+	yamlNode := Node{}
+	if err := yaml.Unmarshal(yamlBytes, &yamlNode); err != nil {
+		t.Fatalf("yaml.Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(yamlNode, n) {
+		t.Errorf("Got %v from YAML %q, want %v", yamlNode, yamlBytes, n)
+	}
+
+	// Both representations can be used in YAML.
+	yamlNode = Node{}
+	if err := yaml.Unmarshal([]byte("a: !delete\nb:\n  c: {$delete: true}\n"), &yamlNode); err != nil {
+		t.Fatalf("yaml.Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(yamlNode, n) {
+		t.Errorf("Got %v from YAML, want %v", yamlNode, n)
+	}
+
+	// Tombstones are not visible with Get().
+	var v interface{}
+	if err := n.Get(".a", &v); err == nil {
+		t.Errorf("Get() didn't fail for a tombstone")
+	}
+	var s struct {
+		A string `conf:"a"`
+	}
+	if err := n.Get("", &s); err != nil {
+		t.Errorf("Get() failed: %v", err)
+	}
+}
+
+func TestUnmarshalYAMLElement(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{"Empty", "", nil},
+		{"Value", "123\n", Number("123")},
+		{"Tombstone", "!delete\n", Tombstone{}},
+		{"List", "- a\n- !delete\n", []interface{}{"a", Tombstone{}}},
+		{"Node", "a: !delete\nb:\n  c: {$delete: true}\n", Node{"a": Tombstone{}, "b": Node{"c": Tombstone{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshalYAMLElement([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalYAMLElement() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalYAMLElement() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
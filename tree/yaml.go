@@ -8,14 +8,19 @@ package tree
 import (
 	"fmt"
 	"reflect"
+
+	"gopkg.in/yaml.v3"
 )
 
 // UnmarshalYAML will unmarshal yaml data into a Node object.
 // It converts anything to valid tree objects.
-func (n Node) UnmarshalYAML(unmarshal func(v interface{}) error) error {
+//
+// Elements with the !delete tag are converted into tombstones.
+func (n Node) UnmarshalYAML(value *yaml.Node) error {
+	replaceTombstoneTags(value)
 
 	var root map[string]interface{}
-	err := unmarshal(&root)
+	err := value.Decode(&root)
 	if err != nil {
 		return err
 	}
@@ -38,3 +43,26 @@ func (n Node) UnmarshalYAML(unmarshal func(v interface{}) error) error {
 
 	return nil
 }
+
+// UnmarshalYAMLElement will unmarshal yaml data into a tree element.
+// Unlike UnmarshalYAML, the data doesn't have to be a mapping, it can be a list or a value too.
+// An empty document results in nil.
+//
+// Elements with the !delete tag are converted into tombstones.
+func UnmarshalYAMLElement(data []byte) (interface{}, error) {
+	var value yaml.Node
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if value.Kind == 0 {
+		return nil, nil // Empty document.
+	}
+	replaceTombstoneTags(&value)
+
+	var root interface{}
+	if err := value.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	return marshal(reflect.ValueOf(root))
+}
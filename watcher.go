@@ -0,0 +1,374 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fallbackPollInterval is used when filesystem events are not available.
+const fallbackPollInterval = 1 * time.Second
+
+// maxWatcherErrors is the number of errors a watcher keeps until they are taken with takeErrors().
+const maxWatcherErrors = 10
+
+// watcher watches a set of directories, and signals any change to a channel.
+//
+// The list of directories is evaluated again after every event.
+// This way the watcher re-arms itself when directories are created, removed or swapped.
+//
+// Alternatively the watcher can poll for changes, see newPollingWatcher().
+type watcher struct {
+	fsWatcher *fsnotify.Watcher      // Is nil when polling.
+	dirs      func() []string        // Returns the directories that should be watched.
+	filter    func(name string) bool // Returns whether an event for the given path should be signaled. Can be nil.
+	watched   map[string]string      // Currently watched directories, mapped to the path they resolved to when they were added.
+	standIns  map[string][]string    // Ancestors that are watched instead of directories that don't exist, mapped to these directories.
+
+	errMutex sync.Mutex
+	errs     []error // Errors that occurred while watching, see takeErrors().
+
+	stopChan chan struct{} // Is closed to stop polling.
+	doneChan chan struct{}
+}
+
+// newWatcher starts to watch the directories returned by dirs.
+// Any relevant event is written in a non blocking way to changeChan.
+func newWatcher(dirs func() []string, filter func(name string) bool, changeChan chan<- struct{}) (*watcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		fsWatcher: fsWatcher,
+		dirs:      dirs,
+		filter:    filter,
+		watched:   map[string]string{},
+		doneChan:  make(chan struct{}),
+	}
+
+	if err := w.arm(); err != nil {
+		fsWatcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer close(w.doneChan)
+
+		for {
+			select {
+			case e, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+				if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					w.forget(e.Name)
+				}
+				// Has to be checked before the watcher is armed again, as that changes the stand-ins.
+				standIn, relevant := w.standInEvent(e.Name)
+				w.arm() // Errors are ignored, the next event will try again.
+				if standIn && !relevant {
+					continue
+				}
+				// Events for missing directories are always signaled, as files may have been created before the directory was watched.
+				if !standIn && w.filter != nil && !w.filter(e.Name) {
+					continue
+				}
+				// Write to changeChan in a non blocking way.
+				select {
+				case changeChan <- struct{}{}:
+				default:
+				}
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				w.addError(err)
+				// Changes may have been lost (e.g. because the event queue overflowed), so signal a change anyways.
+				select {
+				case changeChan <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// arm adds all directories that are not watched yet, and removes the ones that shouldn't be watched anymore.
+//
+// Directories that don't exist are replaced by their nearest existing ancestor.
+// This way the creation of the directory is noticed, and it is watched again afterwards.
+func (w *watcher) arm() error {
+	var firstErr error
+
+	w.standIns = map[string][]string{}
+	dirs := map[string]struct{}{}
+	add := func(dir string) error {
+		dirs[dir] = struct{}{}
+		resolved, _ := filepath.EvalSymlinks(dir)
+		if watchedResolved, ok := w.watched[dir]; ok {
+			if watchedResolved == resolved {
+				return nil
+			}
+			// The directory is a symlink that points somewhere else now, watch it again.
+			w.forget(dir)
+		}
+		if err := w.fsWatcher.Add(dir); err != nil {
+			delete(dirs, dir)
+			return err
+		}
+		w.watched[dir] = resolved
+		return nil
+	}
+
+	for _, dir := range w.dirs() {
+		dir = filepath.Clean(dir)
+		err := add(dir)
+		for ancestor := dir; err != nil && errors.Is(err, os.ErrNotExist) && ancestor != filepath.Dir(ancestor); {
+			ancestor = filepath.Dir(ancestor)
+			if err = add(ancestor); err == nil {
+				w.standIns[ancestor] = append(w.standIns[ancestor], dir)
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for dir := range w.watched {
+		if _, ok := dirs[dir]; !ok {
+			w.fsWatcher.Remove(dir) // Will fail if the directory doesn't exist anymore, which is fine.
+			delete(w.watched, dir)
+		}
+	}
+
+	return firstErr
+}
+
+// newPollingWatcher checks the result of fingerprint in the given interval, and signals any change of it to changeChan.
+//
+// This is useful for network and overlay filesystems that don't support filesystem events.
+func newPollingWatcher(fingerprint func() string, interval time.Duration, changeChan chan<- struct{}) *watcher {
+	w := &watcher{
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+
+	last := fingerprint()
+
+	go func() {
+		defer close(w.doneChan)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				current := fingerprint()
+				if current == last {
+					continue
+				}
+				last = current
+				// Write to changeChan in a non blocking way.
+				select {
+				case changeChan <- struct{}{}:
+				default:
+				}
+			case <-w.stopChan:
+				return
+			}
+		}
+	}()
+
+	return w
+}
+
+// standInEvent returns whether the event is inside an ancestor that is watched instead of a missing directory,
+// and if so, whether it affects the path to any of the missing directories.
+func (w *watcher) standInEvent(name string) (standIn, relevant bool) {
+	name = filepath.Clean(name)
+	missingDirs, ok := w.standIns[filepath.Dir(name)]
+	if !ok {
+		return false, false
+	}
+	for _, dir := range missingDirs {
+		if dir == name || strings.HasPrefix(dir, name+string(filepath.Separator)) {
+			return true, true
+		}
+	}
+	return true, false
+}
+
+// addError stores the error, so that it can be reported later.
+func (w *watcher) addError(err error) {
+	w.errMutex.Lock()
+	defer w.errMutex.Unlock()
+
+	if len(w.errs) < maxWatcherErrors {
+		w.errs = append(w.errs, fmt.Errorf("watching for changes failed: %w", err))
+	}
+}
+
+// takeErrors returns all errors that occurred since the last call.
+// It's safe to call this on a nil watcher.
+func (w *watcher) takeErrors() []error {
+	if w == nil {
+		return nil
+	}
+
+	w.errMutex.Lock()
+	defer w.errMutex.Unlock()
+
+	errs := w.errs
+	w.errs = nil
+	return errs
+}
+
+// forget removes the given path from the list of watched directories, so that it is added again on the next arm.
+// This is needed, as a watch doesn't survive its directory being removed or moved.
+func (w *watcher) forget(path string) {
+	path = filepath.Clean(path)
+	if _, ok := w.watched[path]; ok {
+		w.fsWatcher.Remove(path) // Will fail if the directory doesn't exist anymore, which is fine.
+		delete(w.watched, path)
+	}
+}
+
+// Close stops watching, and waits until no more changes are signaled.
+func (w *watcher) Close() error {
+	if w.fsWatcher == nil {
+		close(w.stopChan)
+		<-w.doneChan
+		return nil
+	}
+
+	err := w.fsWatcher.Close()
+	<-w.doneChan
+	return err
+}
+
+// newFileWatcher watches the file at the given path, and signals any change to changeChan.
+//
+// Instead of the file itself, the directory that contains the file is watched.
+// This way the watch survives the file being replaced by a rename (like editors and Write() do), removed or created.
+// If the file is a symlink, the directory of the symlink target is watched too, and any change of the target is signaled.
+//
+// If pollInterval is larger than 0, or if filesystem events are not available, the file is polled instead.
+func newFileWatcher(path string, pollInterval time.Duration, changeChan chan<- struct{}) *watcher {
+	path = filepath.Clean(path)
+	fingerprint := func() string {
+		return fileFingerprint(path)
+	}
+
+	if pollInterval > 0 {
+		return newPollingWatcher(fingerprint, pollInterval, changeChan)
+	}
+
+	resolved := resolvePath(path)
+
+	dirs := func() []string {
+		result := []string{filepath.Dir(path)}
+		if resolvedDir := filepath.Dir(resolvePath(path)); resolvedDir != result[0] {
+			result = append(result, resolvedDir)
+		}
+		return result
+	}
+
+	filter := func(name string) bool {
+		name = filepath.Clean(name)
+		newResolved := resolvePath(path)
+		relevant := name == path || name == resolved || name == newResolved || newResolved != resolved
+		resolved = newResolved
+		return relevant
+	}
+
+	w, err := newWatcher(dirs, filter, changeChan)
+	if err != nil {
+		return newPollingWatcher(fingerprint, fallbackPollInterval, changeChan)
+	}
+
+	return w
+}
+
+// newDirWatcher watches all directories returned by dirs, and signals any change inside of them to changeChan.
+//
+// If pollInterval is larger than 0, or if filesystem events are not available, the directories are polled instead.
+func newDirWatcher(dirs func() []string, pollInterval time.Duration, changeChan chan<- struct{}) *watcher {
+	fingerprint := func() string {
+		return dirFingerprint(dirs())
+	}
+
+	if pollInterval > 0 {
+		return newPollingWatcher(fingerprint, pollInterval, changeChan)
+	}
+
+	w, err := newWatcher(dirs, nil, changeChan)
+	if err != nil {
+		return newPollingWatcher(fingerprint, fallbackPollInterval, changeChan)
+	}
+
+	return w
+}
+
+// fileFingerprint returns a string that changes whenever the modification time, size or content of the file changes.
+func fileFingerprint(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	if info.IsDir() {
+		return fmt.Sprintf("directory %d", info.ModTime().UnixNano())
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	return fmt.Sprintf("%d %d %x", info.ModTime().UnixNano(), info.Size(), sha256.Sum256(buf))
+}
+
+// dirFingerprint returns a string that changes whenever any of the direct children of the given directories change.
+func dirFingerprint(dirs []string) string {
+	h := sha256.New()
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			fmt.Fprintf(h, "%s error: %v\n", dir, err)
+			continue
+		}
+		for _, info := range infos {
+			entryPath := filepath.Join(dir, info.Name())
+			fmt.Fprintf(h, "%s %s\n", entryPath, fileFingerprint(entryPath))
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// resolvePath returns the path with all symlinks evaluated.
+// If that's not possible, because the file doesn't exist, the path is returned as it is.
+func resolvePath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
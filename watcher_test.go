@@ -0,0 +1,241 @@
+// Copyright (c) 2019-2023 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// expectChange waits until something is signaled on changeChan, and drains it afterwards.
+func expectChange(t *testing.T, changeChan <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-changeChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No change signaled after %s", what)
+	}
+
+	// Drain any further events that belong to the same modification.
+	for {
+		select {
+		case <-changeChan:
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "config.json")
+
+	// Start watching a file that doesn't exist yet.
+	f := UseJSONFile(filePath)
+	changeChan := make(chan struct{}, 1)
+	if err := f.RegisterWatcher(changeChan); err != nil {
+		t.Fatalf("RegisterWatcher() failed: %v", err)
+	}
+	defer f.RegisterWatcher(nil)
+
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "creating the file")
+
+	// Replace the file by renaming, multiple times.
+	for i := 0; i < 3; i++ {
+		tempPath := filepath.Join(dir, "config.json.swp")
+		if err := ioutil.WriteFile(tempPath, []byte(`{"a": 2}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tempPath, filePath); err != nil {
+			t.Fatal(err)
+		}
+		expectChange(t, changeChan, "renaming over the file")
+	}
+
+	// Remove and recreate.
+	if err := os.Remove(filePath); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "removing the file")
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "recreating the file")
+
+	// Changes to other files in the same directory are not signaled.
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changeChan:
+		t.Errorf("Change of an unrelated file was signaled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPollingWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := Polling(UseJSONFile(filePath), 10*time.Millisecond)
+	changeChan := make(chan struct{}, 1)
+	if err := f.RegisterWatcher(changeChan); err != nil {
+		t.Fatalf("RegisterWatcher() failed: %v", err)
+	}
+	defer f.RegisterWatcher(nil)
+
+	// Same size, and possibly the same modification time. Only the content hash differs.
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "modifying the file")
+
+	select {
+	case <-changeChan:
+		t.Errorf("Change was signaled without modification")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMissingDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The directory doesn't exist, so its nearest existing ancestor is watched instead.
+	subDir := filepath.Join(dir, "sub")
+	filePath := filepath.Join(subDir, "config.json")
+
+	f := UseJSONFile(filePath)
+	changeChan := make(chan struct{}, 1)
+	if err := f.RegisterWatcher(changeChan); err != nil {
+		t.Fatalf("RegisterWatcher() failed: %v", err)
+	}
+	defer f.RegisterWatcher(nil)
+
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "creating the file")
+}
+
+func TestWatcherRemovedDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	subDir := filepath.Join(dir, "sub")
+	filePath := filepath.Join(subDir, "config.json")
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := UseJSONFile(filePath)
+	changeChan := make(chan struct{}, 1)
+	if err := f.RegisterWatcher(changeChan); err != nil {
+		t.Fatalf("RegisterWatcher() failed: %v", err)
+	}
+	defer f.RegisterWatcher(nil)
+
+	// Remove the watched directory, and create it again.
+	if err := os.RemoveAll(subDir); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "removing the directory")
+
+	// Unrelated changes inside the ancestor are not signaled.
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changeChan:
+		t.Errorf("Change of an unrelated file was signaled")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "recreating the directory and the file")
+
+	// Modifications are signaled again.
+	if err := ioutil.WriteFile(filePath, []byte(`{"a": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changeChan, "modifying the file")
+}
+
+func TestWatcherError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "D3config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	changeChan := make(chan struct{}, 1)
+	w := newFileWatcher(filepath.Join(dir, "config.json"), 0, changeChan)
+	defer w.Close()
+	if w.fsWatcher == nil {
+		t.Skip("Filesystem events are not available")
+	}
+
+	// Errors, like an overflowing event queue, force a reload and are kept to be reported.
+	w.fsWatcher.Errors <- errors.New("queue overflow")
+	expectChange(t, changeChan, "an error")
+
+	if errs := w.takeErrors(); len(errs) != 1 {
+		t.Errorf("takeErrors() returned %v, want one error", errs)
+	}
+	if errs := w.takeErrors(); len(errs) != 0 {
+		t.Errorf("takeErrors() returned %v after the errors were taken", errs)
+	}
+}
+
+func TestPollingLayer(t *testing.T) {
+	f := UseJSONFile(filepath.Join("testfiles", "json", "a.json")).(PollingStorage)
+
+	l := Polling(Optional(Locked(f, ".a")), 10*time.Millisecond)
+	if _, ok := l.(*Layer); !ok {
+		t.Errorf("Polling() returned %T, want the layer itself", l)
+	}
+	if got := f.PollInterval(); got != 10*time.Millisecond {
+		t.Errorf("PollInterval() of the wrapped storage = %v, want %v", got, 10*time.Millisecond)
+	}
+}